@@ -17,8 +17,13 @@ limitations under the License.
 */
 
 import (
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"time"
 
 	"github.com/golang/glog"
@@ -42,31 +47,158 @@ var (
 	blackboxFile   = flag.String("blackboxfile", "blackbox.yaml", "file to write the generated blackbox config to")
 	onlySC         = flag.Bool("onlysc", false, "if true, only write out scrapeconfigs")
 	jobName        = flag.String("jobname", "blackbox", "job_name for the target definition")
+	targetsFormat  = flag.String("targets_format", "static", "how to emit targets: static (in the scrape config), file_sd (in --filesdfile) or operator (Prometheus Operator Probes)")
+	fileSDFile     = flag.String("filesdfile", "targets.json", "file to write file_sd targets to when --targets_format=file_sd")
+	namespace      = flag.String("namespace", "", "kubernetes namespace for --targets_format=operator")
+	configMapFile  = flag.String("configmapfile", "blackbox-configmap.yaml", "file to write the blackbox config ConfigMap to when --targets_format=operator")
+	configMapName  = flag.String("configmapname", "blackbox-exporter-config", "name of the blackbox config ConfigMap")
+	configSecret   = flag.Bool("configsecret", false, "if true, wrap the blackbox config in a Secret instead of a ConfigMap")
+	rulesFile      = flag.String("rulesfile", "", "if set, file to write generated alerting rules to")
+	rulesPerModule = flag.Bool("rules_per_module", false, "if true, generate one alert per module instead of per target")
+	alertFor       = flag.Duration("alert_for", 5*time.Minute, "default for: duration of generated alerts")
+	severity       = flag.String("severity", "page", "default severity label of generated alerts")
+	lint           = flag.Bool("lint", false, "if true, check the config for likely mistakes, and fail without writing anything if there are any")
 )
 
 // Main is the generic Main function.  Pass it a function that uses the Config object, and it will handle flags and output.
+// It exits non-zero if anything goes wrong.
 func Main(cfg func(c *Config)) {
 	flag.Parse()
 
+	if err := Run(context.Background(), cfg); err != nil {
+		glog.Exit(err)
+	}
+}
+
+// Run builds a Config from the command line flags, passes it to cfg, and
+// writes the generated files.  Each file is written atomically, so a reader
+// never sees a partially written config.  All failures are returned.
+func Run(ctx context.Context, cfg func(c *Config)) error {
+	if !flag.Parsed() {
+		flag.Parse()
+	}
+
 	c := &Config{
 		Modules: make(ModuleMap),
 		Targets: &Targets{
 			BlackboxHostPort: *blackbox,
 			ScrapeInterval:   int(scrapeInterval.Seconds()),
 			JobName:          *jobName,
+			AlertFor:         *alertFor,
+			Severity:         *severity,
 		},
 	}
 
 	cfg(c)
 
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("invalid blackbox config: %w", err)
+	}
+	if *lint {
+		var errs []error
+		for _, f := range c.Lint() {
+			errs = append(errs, errors.New(f.String()))
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("lint: %w", errors.Join(errs...))
+		}
+	}
+
+	var errs []error
 	cbs, err := c.Marshal()
 	if err != nil {
-		glog.Fatal(err)
+		errs = append(errs, fmt.Errorf("marshaling blackbox config: %w", err))
+	}
+	files := make(map[string][]byte)
+	switch *targetsFormat {
+	case "static":
+		if !*onlySC {
+			files[*targetsFile], err = c.Targets.Marshal()
+		} else {
+			files[*targetsFile], err = c.Targets.MarshalSC()
+		}
+	case "file_sd":
+		if !*onlySC {
+			files[*targetsFile], err = c.Targets.MarshalFileSDConfig(*fileSDFile)
+		} else {
+			files[*targetsFile], err = c.Targets.MarshalFileSDSC(*fileSDFile)
+		}
+		if err == nil {
+			files[*fileSDFile], err = c.Targets.MarshalFileSD()
+		}
+	case "operator":
+		files[*targetsFile], err = c.Targets.MarshalProbes(*namespace)
+		if err == nil {
+			key := filepath.Base(*blackboxFile)
+			if *configSecret {
+				files[*configMapFile], err = c.MarshalSecret(*configMapName, *namespace, key)
+			} else {
+				files[*configMapFile], err = c.MarshalConfigMap(*configMapName, *namespace, key)
+			}
+		}
+	default:
+		err = fmt.Errorf("unknown --targets_format %q", *targetsFormat)
+	}
+	if err != nil {
+		errs = append(errs, fmt.Errorf("marshaling targets: %w", err))
+	}
+	if *rulesFile != "" {
+		if *rulesPerModule {
+			files[*rulesFile], err = c.Targets.MarshalModuleRules()
+		} else {
+			files[*rulesFile], err = c.Targets.MarshalRules()
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("marshaling rules: %w", err))
+		}
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	files[*blackboxFile] = cbs
+	names := make([]string, 0, len(files))
+	for n := range files {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	for _, n := range names {
+		if err := writeFileAtomic(n, files[n], 0644); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory as
+// name and renames it into place.
+func writeFileAtomic(name string, data []byte, perm os.FileMode) (err error) {
+	f, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("writing %s: %w", name, err)
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			err = fmt.Errorf("writing %s: %w", name, err)
+		}
+	}()
+
+	if _, err = f.Write(data); err != nil {
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
 	}
-	os.WriteFile(*blackboxFile, cbs, 0644)
-	if !*onlySC {
-		os.WriteFile(*targetsFile, c.Targets.Marshal(), 0644)
-	} else {
-		os.WriteFile(*targetsFile, c.Targets.MarshalSC(), 0644)
+	if err = f.Close(); err != nil {
+		return err
 	}
+	return os.Rename(f.Name(), name)
 }
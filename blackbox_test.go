@@ -0,0 +1,139 @@
+package blackbox
+
+/*
+Copyright 2026 Robert Spier
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// setFlag sets a command line flag for the duration of the test.
+func setFlag(t *testing.T, name, value string) {
+	t.Helper()
+	old := flag.Lookup(name).Value.String()
+	if err := flag.Set(name, value); err != nil {
+		t.Fatalf("flag.Set(%q, %q): %v", name, value, err)
+	}
+	t.Cleanup(func() { flag.Set(name, old) })
+}
+
+func TestWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "out.yaml")
+
+	if err := os.WriteFile(name, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeFileAtomic(name, []byte("new"), 0644); err != nil {
+		t.Fatalf("writeFileAtomic failed: %v", err)
+	}
+
+	got, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "new" {
+		t.Errorf("got %q, want %q", got, "new")
+	}
+
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ents) != 1 {
+		t.Errorf("expected only the output file in %s, got %d entries", dir, len(ents))
+	}
+}
+
+func TestWriteFileAtomicMissingDir(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "missing", "out.yaml")
+	if err := writeFileAtomic(name, []byte("x"), 0644); err == nil {
+		t.Error("expected error writing into a missing directory")
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	setFlag(t, "blackboxfile", filepath.Join(dir, "blackbox.yaml"))
+	setFlag(t, "targetsfile", filepath.Join(dir, "prometheus.yaml"))
+
+	err := Run(context.Background(), func(c *Config) {
+		c.AddSimpleRule("https://example.com")
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	for _, f := range []string{"blackbox.yaml", "prometheus.yaml"} {
+		if _, err := os.Stat(filepath.Join(dir, f)); err != nil {
+			t.Errorf("expected %s to be written: %v", f, err)
+		}
+	}
+}
+
+func TestRunWriteError(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	setFlag(t, "blackboxfile", filepath.Join(dir, "blackbox.yaml"))
+	setFlag(t, "targetsfile", filepath.Join(dir, "prometheus.yaml"))
+
+	err := Run(context.Background(), func(c *Config) {
+		c.AddSimpleRule("https://example.com")
+	})
+	if err == nil {
+		t.Fatal("expected Run to report write errors")
+	}
+}
+
+func TestRunCanceled(t *testing.T) {
+	dir := t.TempDir()
+	setFlag(t, "blackboxfile", filepath.Join(dir, "blackbox.yaml"))
+	setFlag(t, "targetsfile", filepath.Join(dir, "prometheus.yaml"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := Run(ctx, func(c *Config) {}); err != context.Canceled {
+		t.Errorf("Run with canceled context = %v; want %v", err, context.Canceled)
+	}
+}
+
+func TestRunLint(t *testing.T) {
+	dir := t.TempDir()
+	setFlag(t, "blackboxfile", filepath.Join(dir, "blackbox.yaml"))
+	setFlag(t, "targetsfile", filepath.Join(dir, "prometheus.yaml"))
+	setFlag(t, "lint", "true")
+
+	err := Run(context.Background(), func(c *Config) {
+		c.AddSimpleRule("https://example.com")
+		c.AddSimpleRule("example.org")
+	})
+	if err == nil || !strings.Contains(err.Error(), "target example.org (module http_200): HTTP target without") {
+		t.Fatalf("Run = %v; want lint finding for example.org", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "blackbox.yaml")); !os.IsNotExist(err) {
+		t.Errorf("expected nothing written after lint findings, got %v", err)
+	}
+
+	if err := Run(context.Background(), func(c *Config) {
+		c.AddSimpleRule("https://example.com")
+	}); err != nil {
+		t.Errorf("Run of clean config failed: %v", err)
+	}
+}
@@ -20,7 +20,6 @@ limitations under the License.
 */
 
 import (
-	bbconfig "github.com/prometheus/blackbox_exporter/config"
 	bb "github.com/rspier/blackbox-configo"
 )
 
@@ -44,10 +43,9 @@ func config(c *bb.Config) {
 	c.AddDNSRule("8.8.8.8", "A", "www.firebase.com", bb.DNSAnswerFailIfNotMatchesRegexp("151.101.1.195", "151.101.65.195"),
 		bb.ScrapeInterval(10))
 
+	c.AddPingRule("8.8.8.8")
+
 	c.AddSMTPRule("localhost:25")
-	c.AddIMAPRule("localhost:993", bb.TCPUseTLS(),
-		bb.CustomFunc(func(m *bbconfig.Module) {
-			m.TCP.TLSConfig.InsecureSkipVerify = true
-		}))
+	c.AddIMAPRule("localhost:993", bb.TCPUseTLS(), bb.TLSInsecureSkipVerify())
 
 }
@@ -17,10 +17,18 @@ limitations under the License.
 */
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"maps"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"runtime"
+	"slices"
 	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	bbconfig "github.com/prometheus/blackbox_exporter/config"
@@ -29,22 +37,78 @@ import (
 
 // Config represents a prometheus blackbox monitoring config, encompassing
 // blackbox_exporter module configuration and prometheus target configuration.
+//
+// The Add*Rule methods may be called from several goroutines.  Marshal the
+// config once they've all returned.  Module names don't depend on the order
+// rules are added in, so the output is the same however the calls interleave.
 type Config struct {
 	Modules ModuleMap
 	Targets *Targets
+
+	mu sync.Mutex
+}
+
+// add adds m, and a target for it at dest.  The target is named name, or
+// after the module if name is empty, without any suffix ModuleMap.Add gives
+// it.  A module with no name names the target with its "mod_" name.  Both
+// are commented with the location of the rule's caller.
+func (c *Config) add(m *Module, dest, name string, os ...*Option) {
+	src := callerSource()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m.source = src
+	if name == "" {
+		name = m.Name
+	}
+	renamed := c.Modules.add(m)
+	if name == "" {
+		name = m.Name
+	}
+	for i, t := range c.Targets.Targets {
+		if n, ok := renamed[t.Module]; ok {
+			c.Targets.Targets[i].Module = n
+		}
+	}
+	c.Targets.Add(m, dest, name, os...)
+	c.Targets.Targets[len(c.Targets.Targets)-1].source = src
+}
+
+// pkgPath is the import path of this package.
+var pkgPath = reflect.TypeFor[Config]().PkgPath()
+
+// callerSource returns the location of the innermost call from outside this
+// package, as "file.go:line".  Only the base name of the file is used, so the
+// output doesn't depend on where the code is checked out.
+func callerSource() string {
+	pcs := make([]uintptr, 32)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		f, more := frames.Next()
+		inPkg := strings.HasPrefix(f.Function, pkgPath+".") && !strings.HasSuffix(f.File, "_test.go")
+		if !inPkg && f.File != "" {
+			return fmt.Sprintf("%s:%d", filepath.Base(f.File), f.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
 }
 
 // We need to check both the production site on the CDN and the local version.
 
 func (c *Config) AddSimpleRule(url string, os ...*Option) {
-	m := &Module{Name: "http_200", Module: BaseHTTPModule(200)}
-	m.applyOptions(os...)
-	c.Modules.Add(m)
+	m := &Module{Module: BaseHTTPModule(200)}
+	m.applyOptions(append(defaults("http_200"), os...)...)
 	n := url
-	if len(os) > 0 {
-		n = m.Name
+	switch {
+	case m.forcedName:
+		n = ""
+	case m.defaultHash != m.hash():
+		// Name the module, and its target, after the module's hash.
+		m.Name = ""
+		n = ""
 	}
-	c.Targets.Add(m, url, n)
+	c.add(m, url, n, os...)
 }
 
 func (c *Config) AddSimpleRuleWithRedirect(url string, os ...*Option) {
@@ -65,9 +129,6 @@ func (c *Config) AddHTTPSRedirRule(in string, os ...*Option) {
 	src := strings.Replace(in, "https://", "http://", 1)
 	dst := strings.Replace(in, "http://", "https://", 1)
 
-	n := cleanName("redir_to_" + strings.TrimPrefix(dst, "http://"))
-
-	os = append(os, Name(n))
 	c.AddRedirRule(src, dst, os...)
 }
 
@@ -75,22 +136,152 @@ func (c *Config) AddRedirRule(src, dst string, os ...*Option) {
 	m := RedirModule(302, dst)
 
 	n := cleanName("redir_to_" + strings.TrimPrefix(dst, "http://"))
-	os = append(os, Name(n))
+	os = append(defaults(n), os...)
 	m.applyOptions(os...)
 
-	c.Modules.Add(m)
-	c.Targets.Add(m, src, m.Name, os...)
+	c.add(m, src, "", os...)
+}
+
+// AddCertExpiryRule alerts when the certificate behind url expires within
+// warnBefore.  https:// URLs are checked with an HTTP module, anything else
+// is treated as a host:port speaking TLS.  If url is already a target of a
+// suitable module and there are no options, that target is reused.  An
+// http:// URL or a warnBefore that isn't positive is an error, reported by
+// Config.Validate.
+func (c *Config) AddCertExpiryRule(url string, warnBefore time.Duration, os ...*Option) {
+	var errs []error
+	if strings.HasPrefix(url, "http://") {
+		errs = append(errs, fmt.Errorf("AddCertExpiryRule(%q): an http:// URL has no certificate", url))
+	}
+	if warnBefore <= 0 {
+		errs = append(errs, fmt.Errorf("AddCertExpiryRule(%q): warnBefore %v isn't positive", url, warnBefore))
+	}
+	if len(errs) == 0 && len(os) == 0 && c.setCertExpiry(url, warnBefore) {
+		return
+	}
+
+	os = append(os, CertExpiry(warnBefore))
+	for _, err := range errs {
+		os = append(os, optionErr(err))
+	}
+	if strings.HasPrefix(url, "https://") || strings.HasPrefix(url, "http://") {
+		c.AddSimpleRule(url, os...)
+		return
+	}
+	os = append(defaults("tcp", TCPUseTLS()), os...)
+	c.AddTCPRule(url, nil, os...)
+}
+
+// setCertExpiry sets the CertExpiry of an existing target of url whose
+// module checks its certificate, and reports whether there was one.
+func (c *Config) setCertExpiry(url string, warnBefore time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, t := range c.Targets.Targets {
+		m, ok := c.Modules[t.Module]
+		if t.Destination == url && ok && m.checksCert() &&
+			(m.Module.Prober != "http" || strings.HasPrefix(url, "https://")) {
+			c.Targets.Targets[i].CertExpiry = warnBefore
+			return true
+		}
+	}
+	return false
+}
+
+// DualStack calls add twice, with options to probe over only IPv4 and only
+// IPv6.  The ip_protocol label tells the two apart.
+//
+//	c.DualStack(func(os ...*Option) { c.AddSimpleRule("https://example.com", os...) })
+func (c *Config) DualStack(add func(os ...*Option), os ...*Option) {
+	for _, p := range []string{"ip4", "ip6"} {
+		add(append(slices.Clone(os), onlyIP(p), Label("ip_protocol", p))...)
+	}
+}
+
+// onlyIP probes over only IP protocol p.  The "_ip4" or "_ip6" suffix it
+// gives the module name is enough to tell the variants of a helper's
+// default module apart, so they keep the helper's name.
+func onlyIP(p string) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			isDefault := m.defaultHash != "" && m.defaultHash == m.hash()
+			IPProtocol(p).ModuleOption(m)
+			IPFallback(false).ModuleOption(m)
+			if isDefault {
+				m.defaultHash = m.hash()
+			}
+		}}
 }
 
 func (c *Config) AddDNSRule(server, qtype, qname string, os ...*Option) {
 	m := DNSModule(qtype, qname)
 	n := cleanName(fmt.Sprintf("dns_%s_%s", qname, qtype))
-	os = append(os, Name(n))
+	os = append(defaults(n), os...)
 
 	m.applyOptions(os...)
 
-	c.Modules.Add(m)
-	c.Targets.Add(m, server, m.Name, os...)
+	c.add(m, server, "", os...)
+}
+
+// AddDNSRecordSetRule checks that server answers the qtype query for qname
+// with exactly the records in values, in any order.  Values are plain record
+// data as printed by "dig +short", e.g. "192.0.2.1", "ns1.example.com." or
+// "\"v=spf1 -all\"".  An empty values checks that there are no answers.
+//
+// The exporter can only require one pattern to be present per probe, so there
+// is a target for each value.  Each fails if the answers include anything not
+// in values, or don't include its value.
+func (c *Config) AddDNSRecordSetRule(server, qtype, qname string, values []string, os ...*Option) {
+	values = slices.Compact(slices.Sorted(slices.Values(values)))
+	var res []string
+	for _, v := range values {
+		res = append(res, dnsRecordRegexp(qtype, v))
+	}
+
+	add := func(suffix string, checks ...*Option) {
+		m := DNSModule(qtype, qname)
+		m.Description = fmt.Sprintf("dns record set for %q", qname)
+		os := append(defaults(cleanName(fmt.Sprintf("dnsset_%s_%s_%s", qname, qtype, suffix)), checks...), os...)
+		m.applyOptions(os...)
+		c.add(m, server, "", os...)
+	}
+
+	if len(values) == 0 {
+		add("empty", DNSAnswerFailIfMatchesRegexp("."))
+		return
+	}
+	for i, v := range values {
+		add(v, DNSAnswerFailIfNotMatchesRegexp(strings.Join(res, "|")), dnsAnswerFailIfNoneMatchesRegexp(res[i]))
+	}
+}
+
+// dnsRecordRegexp matches a resource record of type qtype with data value, as
+// formatted by the exporter: name, TTL, class, type and data, separated by
+// tabs.
+func dnsRecordRegexp(qtype, value string) string {
+	return `^[^\t]+\t\d+\tIN\t` + quoteMeta(qtype) + `\t` + quoteMeta(value) + `$`
+}
+
+// AddPingRule pings host.  Pings share the "icmp" module unless options
+// change it.
+func (c *Config) AddPingRule(host string, os ...*Option) {
+	m := ICMPModule()
+	os = append(defaults("icmp"), os...)
+
+	m.applyOptions(os...)
+
+	c.add(m, host, cleanName("ping_"+host), os...)
+}
+
+// AddGRPCRule checks service on the gRPC server at hostport using the gRPC
+// health checking protocol.  An empty service checks the whole server.
+func (c *Config) AddGRPCRule(hostport, service string, os ...*Option) {
+	m := GRPCModule(service)
+	os = append(defaults(m.Name), os...)
+
+	m.applyOptions(os...)
+
+	c.add(m, hostport, "", os...)
 }
 
 func (c *Config) AddTCPRule(server string, qr []bbconfig.QueryResponse, os ...*Option) {
@@ -99,52 +290,165 @@ func (c *Config) AddTCPRule(server string, qr []bbconfig.QueryResponse, os ...*O
 
 	m.applyOptions(os...)
 
-	c.Modules.Add(m)
-	c.Targets.Add(m, server, m.Name, os...)
+	c.add(m, server, "", os...)
 }
 
 func (c *Config) AddSMTPRule(server string, os ...*Option) {
-	os = append([]*Option{Name("smtp"), Timeout(5 * time.Second)}, os...)
-	c.AddTCPRule(server,
-		[]bbconfig.QueryResponse{
+	os = append(defaults("smtp", Timeout(5*time.Second)), os...)
+	c.AddTCPRule(server, smtpQueryResponse(false), os...)
+}
+
+// AddSMTPStartTLSRule is like AddSMTPRule, but fails unless the server offers
+// STARTTLS and the TLS handshake succeeds.  The certificate is verified, so
+// use TLSConfig to set the server name or CA if needed.
+func (c *Config) AddSMTPStartTLSRule(server string, os ...*Option) {
+	os = append(defaults("smtp_starttls", Timeout(10*time.Second)), os...)
+	c.AddTCPRule(server, smtpQueryResponse(true), os...)
+}
+
+func smtpQueryResponse(starttls bool) []bbconfig.QueryResponse {
+	qr := []bbconfig.QueryResponse{
+		bbconfig.QueryResponse{
+			// Some mail servers return '220-' instead of '220 '. This is
+			// probably a bug.
+			Expect: bbconfig.MustNewRegexp("^220[ -]([^ ]+) ESMTP(.+)?$"),
+		},
+	}
+	if starttls {
+		qr = append(qr,
 			bbconfig.QueryResponse{
-				// Some mail servers return '220-' instead of '220 '. This is
-				// probably a bug.
-				Expect: bbconfig.MustNewRegexp("^220[ -]([^ ]+) ESMTP(.+)?$"),
+				Send: "EHLO prober\r",
 			},
 			bbconfig.QueryResponse{
-				Send: "HELO prober\r",
+				// The capability may be on the last line, "250 STARTTLS".
+				Expect: bbconfig.MustNewRegexp("^250[ -]STARTTLS"),
 			},
 			bbconfig.QueryResponse{
-				Expect: bbconfig.MustNewRegexp("^250 "),
+				Send: "STARTTLS\r",
 			},
-			// TODO: We can support STARTTLS by conditionally inserting STARTTLS and a EHLO here.
 			bbconfig.QueryResponse{
-				Send: "QUIT\r",
+				Expect: bbconfig.MustNewRegexp("^220"),
+			},
+			bbconfig.QueryResponse{
+				StartTLS: true,
+			},
+			bbconfig.QueryResponse{
+				Send: "EHLO prober\r",
 			},
+		)
+	} else {
+		qr = append(qr,
 			bbconfig.QueryResponse{
-				Expect: bbconfig.MustNewRegexp("^221 "),
+				Send: "HELO prober\r",
 			},
+		)
+	}
+	return append(qr,
+		bbconfig.QueryResponse{
+			Expect: bbconfig.MustNewRegexp("^250 "),
 		},
-		os...)
+		bbconfig.QueryResponse{
+			Send: "QUIT\r",
+		},
+		bbconfig.QueryResponse{
+			Expect: bbconfig.MustNewRegexp("^221 "),
+		},
+	)
 }
 
+// AddIMAPRule checks the greeting and CAPABILITY response of an IMAP
+// server, then logs out.  Use IMAPCapabilities to require capabilities.
 func (c *Config) AddIMAPRule(server string, os ...*Option) {
-	os = append([]*Option{Name("imap"), Timeout(5 * time.Second)}, os...)
-	c.AddTCPRule(server,
-		[]bbconfig.QueryResponse{
+	os = append(defaults("imap", Timeout(5*time.Second)), os...)
+	c.AddTCPRule(server, imapQueryResponse(false), os...)
+}
+
+// AddIMAPStartTLSRule is like AddIMAPRule, but requires STARTTLS (usually on
+// port 143) and checks the capabilities after the TLS handshake.
+func (c *Config) AddIMAPStartTLSRule(server string, os ...*Option) {
+	os = append(defaults("imap_starttls", Timeout(5*time.Second)), os...)
+	c.AddTCPRule(server, imapQueryResponse(true), os...)
+}
+
+const imapLogout = "z LOGOUT\r"
+
+// imapCapability sends a CAPABILITY command tagged tag, and expects the
+// untagged response to match re, and then a tagged OK.
+func imapCapability(tag, re string) []bbconfig.QueryResponse {
+	return []bbconfig.QueryResponse{
+		bbconfig.QueryResponse{
+			Send: tag + " CAPABILITY\r",
+		},
+		bbconfig.QueryResponse{
+			Expect: bbconfig.MustNewRegexp(`(?i)^\* CAPABILITY ` + re),
+		},
+		bbconfig.QueryResponse{
+			Expect: bbconfig.MustNewRegexp(`^` + tag + ` OK`),
+		},
+	}
+}
+
+// imapHasCapability returns a regexp matching the rest of a CAPABILITY
+// response that includes c.
+func imapHasCapability(c string) string {
+	return `(?:.* )?` + regexp.QuoteMeta(c) + `(?: |$)`
+}
+
+func imapQueryResponse(starttls bool) []bbconfig.QueryResponse {
+	qr := []bbconfig.QueryResponse{
+		bbconfig.QueryResponse{
+			Expect: bbconfig.MustNewRegexp(`^\* OK`),
+		},
+	}
+	if starttls {
+		qr = append(qr, imapCapability("a1", imapHasCapability("STARTTLS"))...)
+		qr = append(qr,
 			bbconfig.QueryResponse{
-				Expect: bbconfig.MustNewRegexp(`^\* OK \[.+IMAP4.+`),
+				Send: "a2 STARTTLS\r",
 			},
 			bbconfig.QueryResponse{
-				Send: "QUIT\r",
+				Expect: bbconfig.MustNewRegexp(`^a2 OK`),
+			},
+			bbconfig.QueryResponse{
+				StartTLS: true,
 			},
+		)
+	}
+	qr = append(qr, imapCapability("a3", `IMAP4`)...)
+	return append(qr,
+		bbconfig.QueryResponse{
+			Send: imapLogout,
 		},
-		os...)
+		bbconfig.QueryResponse{
+			Expect: bbconfig.MustNewRegexp(`^z OK`),
+		},
+	)
+}
+
+// IMAPCapabilities requires the IMAP server to advertise each of caps, e.g.
+// "IDLE".  Use it with AddIMAPRule or AddIMAPStartTLSRule.
+func IMAPCapabilities(caps ...string) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			opt := fmt.Sprintf("IMAPCapabilities(%v)", caps)
+			m.Description += opt + " "
+			qr := m.Module.TCP.QueryResponse
+			i := slices.IndexFunc(qr, func(q bbconfig.QueryResponse) bool { return q.Send == imapLogout })
+			if i < 0 {
+				m.errs = append(m.errs, fmt.Errorf("%s: only works with AddIMAPRule and AddIMAPStartTLSRule", opt))
+				return
+			}
+			var checks []bbconfig.QueryResponse
+			for j, c := range caps {
+				checks = append(checks, imapCapability(fmt.Sprintf("c%d", j+1), imapHasCapability(c))...)
+			}
+			m.Module.TCP.QueryResponse = slices.Insert(slices.Clone(qr), i, checks...)
+		},
+	}
 }
 
 func (c *Config) AddNNTPRule(server string, os ...*Option) {
-	os = append([]*Option{Name("nntp"), Timeout(10 * time.Second)}, os...)
+	os = append(defaults("nntp", Timeout(10*time.Second)), os...)
 	c.AddTCPRule(server,
 		[]bbconfig.QueryResponse{
 			bbconfig.QueryResponse{
@@ -162,7 +466,17 @@ type BBConfig struct {
 	Modules yaml.Node `yaml:"modules"`
 }
 
+// Marshal returns the modules as a blackbox_exporter config file.  Options
+// that couldn't be applied, like a regexp that doesn't compile, are returned
+// as errors, as Validate reports them.
 func (c *Config) Marshal() ([]byte, error) {
+	if errs := c.optionErrs(); len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return c.marshal()
+}
+
+func (c *Config) marshal() ([]byte, error) {
 	keys := []string{}
 	var bbm = make(map[string]bbconfig.Module)
 	for n, m := range c.Modules {
@@ -175,9 +489,18 @@ func (c *Config) Marshal() ([]byte, error) {
 	bbc.Modules.Kind = yaml.MappingNode
 	for _, k := range keys {
 		var n yaml.Node
-		n.Encode(bbm[k])
+		if err := n.Encode(bbm[k]); err != nil {
+			return nil, err
+		}
+		m := bbm[k]
+		if c.Modules[k].noFallback && !ipFallback(&m) {
+			explicitFalse(&n, m.Prober, "ip_protocol_fallback")
+		}
+		if c.Modules[k].noRecursion && m.Prober == "dns" && !m.DNS.Recursion {
+			explicitFalse(&n, "dns", "recursion_desired")
+		}
 		bbc.Modules.Content = append(bbc.Modules.Content,
-			&yaml.Node{Kind: yaml.ScalarNode, Value: k},
+			&yaml.Node{Kind: yaml.ScalarNode, Value: k, HeadComment: c.Modules[k].comment()},
 			&n,
 		)
 	}
@@ -185,6 +508,88 @@ func (c *Config) Marshal() ([]byte, error) {
 	return yaml.Marshal(&bbc)
 }
 
+// Validate checks that blackbox_exporter will accept the output of Marshal.
+// Each module is decoded the same way the exporter loads its config file, and
+// every module that fails is reported by name.  Invalid options, like a
+// regexp that doesn't compile, are reported along with the targets of the
+// module.
+func (c *Config) Validate() error {
+	errs := c.optionErrs()
+
+	bs, err := c.marshal()
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+
+	var raw struct {
+		Modules yaml.Node `yaml:"modules"`
+	}
+	if err := yaml.Unmarshal(bs, &raw); err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+
+	mc := raw.Modules.Content
+	for i := 0; i+1 < len(mc); i += 2 {
+		name := mc[i].Value
+		if err := validateModule(mc[i+1]); err != nil {
+			errs = append(errs, fmt.Errorf("module %q: %w", name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// optionErrs returns the errors recorded by the modules' options, with the
+// targets of each module.
+func (c *Config) optionErrs() []error {
+	var errs []error
+	for _, n := range slices.Sorted(maps.Keys(c.Modules)) {
+		m := c.Modules[n]
+		if len(m.errs) == 0 {
+			continue
+		}
+		var dests []string
+		for _, t := range c.Targets.Targets {
+			if t.Module == n {
+				dests = append(dests, t.Destination)
+			}
+		}
+		for _, err := range m.errs {
+			errs = append(errs, fmt.Errorf("module %q (targets %v): %w", n, dests, err))
+		}
+	}
+	return errs
+}
+
+func validateModule(n *yaml.Node) error {
+	bs, err := yaml.Marshal(n)
+	if err != nil {
+		return err
+	}
+	d := yaml.NewDecoder(bytes.NewReader(bs))
+	d.KnownFields(true)
+	var m bbconfig.Module
+	return d.Decode(&m)
+}
+
+// explicitFalse adds "key: false" to the prober's section of n.  Encoding
+// drops false because of omitempty, and the exporter defaults key to true.
+func explicitFalse(n *yaml.Node, prober, key string) {
+	var sec *yaml.Node
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == prober {
+			sec = n.Content[i+1]
+		}
+	}
+	if sec == nil {
+		sec = &yaml.Node{Kind: yaml.MappingNode}
+		n.Content = append(n.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: prober}, sec)
+	}
+	sec.Content = append(sec.Content,
+		&yaml.Node{Kind: yaml.ScalarNode, Value: key},
+		&yaml.Node{Kind: yaml.ScalarNode, Tag: "!!bool", Value: "false"},
+	)
+}
+
 func (c *Config) BBModules() bbconfig.Config {
 	var bbm = make(map[string]bbconfig.Module)
 	for n, m := range c.Modules {
@@ -17,14 +17,22 @@ limitations under the License.
 */
 
 import (
+	"crypto/tls"
 	"flag"
+	"fmt"
+	"net"
 	"os"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/miekg/dns"
 	bbconfig "github.com/prometheus/blackbox_exporter/config"
+	promconfig "github.com/prometheus/common/config"
 )
 
 func TestCleanName(t *testing.T) {
@@ -259,4 +267,628 @@ func TestHTTPModule(t *testing.T) {
 	}
 }
 
+func TestValidate(t *testing.T) {
+	c := &Config{
+		Modules: make(ModuleMap),
+		Targets: &Targets{},
+	}
+	c.AddSimpleRule("https://example.com", Contains("ok"))
+	c.AddDNSRule("8.8.8.8", "A", "example.com")
+	c.AddSMTPRule("mail.example.com")
+
+	if err := c.Validate(); err != nil {
+		t.Errorf("expected valid config, got: %v", err)
+	}
+
+	c.AddDNSRule("8.8.8.8", "BOGUS", "bad.example.com")
+	c.AddSimpleRule("https://example.com/header", CustomFunc(func(m *bbconfig.Module) {
+		m.HTTP.FailIfHeaderNotMatchesRegexp = []bbconfig.HeaderMatch{{
+			Regexp: bbconfig.MustNewRegexp("x"),
+		}}
+	}))
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("expected Validate to fail")
+	}
+	for _, want := range []string{"dns_bad_example_com_BOGUS", "query type", "header name must be set"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestDualStack(t *testing.T) {
+	c := &Config{
+		Modules: make(ModuleMap),
+		Targets: &Targets{},
+	}
+	c.DualStack(func(os ...*Option) { c.AddSimpleRule("https://example.com", os...) })
+	c.DualStack(func(os ...*Option) { c.AddDNSRule("8.8.8.8", "AAAA", "example.com", os...) })
+	c.DualStack(func(os ...*Option) { c.AddSMTPRule("mail.example.com:25", os...) })
+
+	if len(c.Targets.Targets) != 6 {
+		t.Fatalf("expected 6 targets, got %d", len(c.Targets.Targets))
+	}
+	for _, tgt := range c.Targets.Targets {
+		p := tgt.Labels["ip_protocol"]
+		if p != "ip4" && p != "ip6" {
+			t.Errorf("target %+v: expected an ip_protocol label", tgt)
+		}
+		m := c.Modules[tgt.Module]
+		if m == nil {
+			t.Fatalf("target %+v: missing module", tgt)
+		}
+		if !strings.HasSuffix(tgt.Module, "_"+p) {
+			t.Errorf("expected module name %q to end in _%s", tgt.Module, p)
+		}
+		if ipFallback(m.Module) {
+			t.Errorf("module %q: expected fallback to be disabled", tgt.Module)
+		}
+	}
+
+	for _, n := range []string{"http_200_ip4", "http_200_ip6", "dns_example_com_AAAA_ip6", "smtp_ip4"} {
+		if _, ok := c.Modules[n]; !ok {
+			t.Errorf("expected module %q", n)
+		}
+	}
+	for _, tgt := range c.Targets.Targets[:2] {
+		if tgt.Name != "https://example.com" {
+			t.Errorf("target %+v: expected the URL as its name", tgt)
+		}
+	}
+
+	got, err := c.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if n := strings.Count(string(got), "ip_protocol_fallback: false"); n != 6 {
+		t.Errorf("expected 6 explicit ip_protocol_fallback: false, got %d:\n%s", n, got)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate failed: %v", err)
+	}
+}
+
+func TestUserModuleKeepsExporterDefaults(t *testing.T) {
+	c := newTestConfig()
+	m := &Module{Name: "raw", Module: &bbconfig.Module{Prober: "http"}}
+	c.Modules.Add(m)
+	c.Targets.Add(m, "https://example.com/", "raw")
+	dm := &Module{Name: "raw_dns", Module: &bbconfig.Module{Prober: "dns", DNS: bbconfig.DNSProbe{QueryName: "example.com"}}}
+	c.Modules.Add(dm)
+	c.Targets.Add(dm, "192.0.2.53", "raw_dns")
+
+	got, err := c.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	if strings.Contains(string(got), "ip_protocol_fallback") {
+		t.Errorf("expected the exporter's default fallback for a module built without options, got:\n%s", got)
+	}
+	if strings.Contains(string(got), "recursion_desired") {
+		t.Errorf("expected the exporter's default recursion for a module built without options, got:\n%s", got)
+	}
+}
+
+func TestExplicitFalseOptionsChangeModule(t *testing.T) {
+	c := newTestConfig()
+	c.AddSimpleRule("https://a.example.com/", Timeout(time.Second))
+	c.AddSimpleRule("https://b.example.com/", Timeout(time.Second), IPFallback(false))
+	c.AddDNSRule("192.0.2.53", "A", "a.example.com", Timeout(time.Second))
+	c.AddDNSRule("192.0.2.53", "A", "a.example.com", Timeout(time.Second), Recursion(false))
+
+	seen := make(map[string]bool)
+	for _, tg := range c.Targets.Targets {
+		if seen[tg.Module] {
+			t.Errorf("target %s shares module %s with a target without its options", tg.Destination, tg.Module)
+		}
+		seen[tg.Module] = true
+	}
+}
+
+func TestAddPingRule(t *testing.T) {
+	c := &Config{
+		Modules: make(ModuleMap),
+		Targets: &Targets{},
+	}
+	c.AddPingRule("router.example.com")
+	c.AddPingRule("10.0.0.1")
+	c.AddPingRule("far.example.com", PayloadSize(1400), DontFragment(), TTL(32), SourceIPAddress("192.0.2.1"), Name("icmp_df"))
+
+	if len(c.Modules) != 2 {
+		t.Errorf("expected 2 modules, got %d", len(c.Modules))
+	}
+	want := []Target{
+		{Module: "icmp", Destination: "router.example.com", Name: "ping_router_example_com"},
+		{Module: "icmp", Destination: "10.0.0.1", Name: "ping_10_0_0_1"},
+		{Module: "icmp_df", Destination: "far.example.com", Name: "ping_far_example_com"},
+	}
+	for i, got := range c.Targets.Targets {
+		if got.Module != want[i].Module || got.Destination != want[i].Destination || got.Name != want[i].Name {
+			t.Errorf("target %d: got %+v, want %+v", i, got, want[i])
+		}
+	}
+
+	icmp := c.Modules["icmp_df"].Module.ICMP
+	if icmp.PayloadSize != 1400 || !icmp.DontFragment || icmp.TTL != 32 || icmp.SourceIPAddress != "192.0.2.1" {
+		t.Errorf("ICMP options not applied: %+v", icmp)
+	}
+
+	got, err := c.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	checkGolden(t, "ping_marshal.golden", got)
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate failed: %v", err)
+	}
+}
+
+func TestAddGRPCRule(t *testing.T) {
+	c := &Config{
+		Modules: make(ModuleMap),
+		Targets: &Targets{},
+	}
+	c.AddGRPCRule("api.example.com:443", "example.v1.Greeter", GRPCUseTLS(),
+		TLSConfig(promconfig.TLSConfig{ServerName: "api.example.com"}))
+	c.AddGRPCRule("localhost:9090", "")
+
+	for _, n := range []string{"grpc_example_v1_Greeter_tls", "grpc"} {
+		if _, ok := c.Modules[n]; !ok {
+			t.Errorf("expected module %q, got %v", n, c.Modules)
+		}
+	}
+	tgt := c.Targets.Targets[0]
+	if tgt.Module != "grpc_example_v1_Greeter_tls" || tgt.Name != tgt.Module {
+		t.Errorf("unexpected target %+v", tgt)
+	}
+
+	got, err := c.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	checkGolden(t, "grpc_marshal.golden", got)
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate failed: %v", err)
+	}
+}
+
+// fakeSMTP speaks just enough SMTP for the probes, offering STARTTLS if
+// cert is non-nil.
+func fakeSMTP(cert *tls.Certificate) func(c *fakeConn) {
+	return func(c *fakeConn) {
+		c.send("220 mail.example.com ESMTP fake")
+		for {
+			cmd := c.recv()
+			switch {
+			case strings.HasPrefix(cmd, "HELO"):
+				c.send("250 mail.example.com")
+			case strings.HasPrefix(cmd, "EHLO"):
+				if cert != nil {
+					c.send("250-mail.example.com", "250-STARTTLS", "250 HELP")
+				} else {
+					c.send("250-mail.example.com", "250 HELP")
+				}
+			case cmd == "STARTTLS" && cert != nil:
+				c.send("220 Ready to start TLS")
+				c.startTLS(*cert)
+			case cmd == "QUIT":
+				c.send("221 Bye")
+				return
+			default:
+				c.send("500 what?")
+				return
+			}
+		}
+	}
+}
+
+func TestSMTPDialogues(t *testing.T) {
+	cert, ca := testCert(t)
+	tlsCfg := TLSConfig(promconfig.TLSConfig{CA: ca})
+
+	tests := []struct {
+		name string
+		add  func(c *Config, server string)
+		tls  bool
+		want bool
+	}{
+		{
+			name: "plain",
+			add:  func(c *Config, s string) { c.AddSMTPRule(s) },
+			want: true,
+		},
+		{
+			name: "starttls",
+			add:  func(c *Config, s string) { c.AddSMTPStartTLSRule(s, tlsCfg) },
+			tls:  true,
+			want: true,
+		},
+		{
+			name: "starttls not offered",
+			add:  func(c *Config, s string) { c.AddSMTPStartTLSRule(s, tlsCfg) },
+			want: false,
+		},
+		{
+			name: "starttls untrusted cert",
+			add:  func(c *Config, s string) { c.AddSMTPStartTLSRule(s) },
+			tls:  true,
+			want: false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			var crt *tls.Certificate
+			if tc.tls {
+				crt = &cert
+			}
+			addr := fakeServer(t, fakeSMTP(crt))
+
+			c := newTestConfig()
+			tc.add(c, addr)
+			if got := runProbe(t, c, addr); got != tc.want {
+				t.Errorf("probe = %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
 
+// fakeIMAP speaks just enough IMAP for the probes.  preTLS and postTLS are
+// the capabilities before and after STARTTLS; STARTTLS is only possible if
+// cert is non-nil.
+func fakeIMAP(cert *tls.Certificate, preTLS, postTLS string) func(c *fakeConn) {
+	return func(c *fakeConn) {
+		caps := preTLS
+		c.send("* OK fake IMAP ready")
+		for {
+			tag, cmd, _ := strings.Cut(c.recv(), " ")
+			switch {
+			case cmd == "CAPABILITY":
+				c.send("* CAPABILITY "+caps, tag+" OK CAPABILITY completed")
+			case cmd == "STARTTLS" && cert != nil:
+				c.send(tag + " OK Begin TLS negotiation now")
+				c.startTLS(*cert)
+				caps = postTLS
+			case cmd == "LOGOUT":
+				c.send("* BYE logging out", tag+" OK LOGOUT completed")
+				return
+			default:
+				c.send(tag + " BAD unknown command")
+				return
+			}
+		}
+	}
+}
+
+func TestIMAPDialogues(t *testing.T) {
+	cert, ca := testCert(t)
+	tlsCfg := TLSConfig(promconfig.TLSConfig{CA: ca})
+
+	tests := []struct {
+		name   string
+		add    func(c *Config, server string)
+		server func(c *fakeConn)
+		want   bool
+	}{
+		{
+			name:   "plain",
+			add:    func(c *Config, s string) { c.AddIMAPRule(s) },
+			server: fakeIMAP(nil, "IMAP4rev1 IDLE", ""),
+			want:   true,
+		},
+		{
+			name:   "not imap",
+			add:    func(c *Config, s string) { c.AddIMAPRule(s) },
+			server: fakeIMAP(nil, "POP3", ""),
+			want:   false,
+		},
+		{
+			name:   "capabilities",
+			add:    func(c *Config, s string) { c.AddIMAPRule(s, IMAPCapabilities("IDLE", "AUTH=PLAIN")) },
+			server: fakeIMAP(nil, "IMAP4rev1 AUTH=PLAIN IDLE", ""),
+			want:   true,
+		},
+		{
+			name:   "missing capability",
+			add:    func(c *Config, s string) { c.AddIMAPRule(s, IMAPCapabilities("IDLE", "AUTH=PLAIN")) },
+			server: fakeIMAP(nil, "IMAP4rev1 IDLEX AUTH=PLAIN", ""),
+			want:   false,
+		},
+		{
+			name:   "starttls",
+			add:    func(c *Config, s string) { c.AddIMAPStartTLSRule(s, tlsCfg, IMAPCapabilities("AUTH=PLAIN")) },
+			server: fakeIMAP(&cert, "IMAP4rev1 STARTTLS LOGINDISABLED", "IMAP4rev1 AUTH=PLAIN"),
+			want:   true,
+		},
+		{
+			name:   "starttls not offered",
+			add:    func(c *Config, s string) { c.AddIMAPStartTLSRule(s, tlsCfg) },
+			server: fakeIMAP(nil, "IMAP4rev1", ""),
+			want:   false,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Parallel()
+			addr := fakeServer(t, tc.server)
+
+			c := newTestConfig()
+			tc.add(c, addr)
+			if got := runProbe(t, c, addr); got != tc.want {
+				t.Errorf("probe = %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+// testDNSHandler answers queries for a few names under example.com.
+func testDNSHandler(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	rr := func(s string) dns.RR {
+		rr, _ := dns.NewRR(s)
+		return rr
+	}
+	switch r.Question[0].Name {
+	case "www.example.com.":
+		m.Answer = append(m.Answer, rr("www.example.com. 300 IN A 192.0.2.1"), rr("www.example.com. 300 IN A 192.0.2.2"))
+		m.Extra = append(m.Extra, rr("ns1.example.com. 300 IN A 192.0.2.53"))
+	case "auth.example.com.":
+		// Only answers queries for its own zone.
+		if r.RecursionDesired {
+			m.Rcode = dns.RcodeRefused
+		} else {
+			m.Answer = append(m.Answer, rr("auth.example.com. 300 IN A 192.0.2.3"))
+		}
+	case "tcp.example.com.":
+		if _, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+			m.Rcode = dns.RcodeRefused
+		} else {
+			m.Answer = append(m.Answer, rr("tcp.example.com. 300 IN A 192.0.2.4"))
+		}
+	default:
+		m.Rcode = dns.RcodeNameError
+	}
+	w.WriteMsg(m)
+}
+
+func TestIMAPCapabilitiesOtherRule(t *testing.T) {
+	c := newTestConfig()
+	c.AddSMTPRule("mail.example.com:25", IMAPCapabilities("IDLE"))
+
+	err := c.Validate()
+	if err == nil || !strings.Contains(err.Error(), "IMAPCapabilities([IDLE]): only works with AddIMAPRule and AddIMAPStartTLSRule") {
+		t.Errorf("expected an error about IMAPCapabilities, got: %v", err)
+	}
+}
+
+func TestDNSOptions(t *testing.T) {
+	cert, pemCert := testCert(t)
+	addr, tlsAddr := fakeDNSServer(t, testDNSHandler, &cert)
+	ca := writeFile(t, "ca.pem", pemCert)
+
+	tests := []struct {
+		name   string
+		server string
+		qname  string
+		os     []*Option
+		want   bool
+	}{
+		{"answer", addr, "www.example.com", nil, true},
+		{"recursion desired", addr, "auth.example.com", nil, false},
+		{"no recursion", addr, "auth.example.com", []*Option{Recursion(false)}, true},
+		{"udp", addr, "tcp.example.com", nil, false},
+		{"tcp", addr, "tcp.example.com", []*Option{TransportProtocol("tcp")}, true},
+		{"dns over tls", tlsAddr, "www.example.com", []*Option{DNSOverTLS(), TLSCAFile(ca)}, true},
+		{"nxdomain", addr, "missing.example.com", nil, false},
+		{"valid nxdomain", addr, "missing.example.com", []*Option{ValidRcodes("NXDOMAIN")}, true},
+		{"additional matches", addr, "www.example.com", []*Option{DNSAdditionalFailIfNotMatchesRegexp(`\t192\.0\.2\.53$`)}, true},
+		{"additional doesn't match", addr, "www.example.com", []*Option{DNSAdditionalFailIfNotMatchesRegexp(`\t192\.0\.2\.54$`)}, false},
+		{"additional fail if matches", addr, "www.example.com", []*Option{DNSAdditionalFailIfMatchesRegexp(`^ns1\.`)}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestConfig()
+			c.AddDNSRule(tc.server, "A", tc.qname, tc.os...)
+			if err := c.Validate(); err != nil {
+				t.Fatalf("Validate failed: %v", err)
+			}
+			if got := runProbe(t, c, tc.server); got != tc.want {
+				t.Errorf("probe = %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestAddDNSRecordSetRule(t *testing.T) {
+	addr, _ := fakeDNSServer(t, testDNSHandler, nil)
+
+	tests := []struct {
+		name   string
+		qname  string
+		values []string
+		os     []*Option
+		want   bool
+	}{
+		{"exact", "www.example.com", []string{"192.0.2.2", "192.0.2.1"}, nil, true},
+		{"missing record", "www.example.com", []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"}, nil, false},
+		{"extra record", "www.example.com", []string{"192.0.2.1"}, nil, false},
+		{"prefix", "www.example.com", []string{"192.0.2.1", "192.0.2.2", "192.0.2.10"}, nil, false},
+		{"no records", "missing.example.com", nil, []*Option{ValidRcodes("NXDOMAIN")}, true},
+		{"unexpected records", "www.example.com", nil, nil, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := newTestConfig()
+			c.AddDNSRecordSetRule(addr, "A", tc.qname, tc.values, tc.os...)
+			if err := c.Validate(); err != nil {
+				t.Fatalf("Validate failed: %v", err)
+			}
+			if got := runProbes(t, c); got != tc.want {
+				t.Errorf("probe = %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestDNSOptionsInvalid(t *testing.T) {
+	c := newTestConfig()
+	c.AddDNSRule("192.0.2.53", "A", "example.com", TransportProtocol("sctp"), ValidRcodes("NOPE"))
+	c.AddSimpleRule("https://example.com/", Recursion(false))
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("expected Validate to report invalid DNS options")
+	}
+	for _, want := range []string{
+		"TransportProtocol(sctp): want udp or tcp",
+		`ValidRcodes([NOPE]): unknown rcode "NOPE"`,
+		"Recursion(false): not supported by the http prober",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
+
+// addTestRules adds a mix of rules, with the i'th of n goroutines adding every
+// n'th one.
+func addTestRules(c *Config, i, n int, shared []*Option) {
+	rules := []func(){
+		func() { c.AddSimpleRule("https://www.example.com/", shared...) },
+		func() { c.AddSimpleRule("https://api.example.com/health", Status(204)) },
+		func() { c.AddSimpleRuleWithRedirect("https://blog.example.com/") },
+		func() { c.AddCertExpiryRule("mail.example.com:993", 14*24*time.Hour) },
+		func() { c.AddDNSRule("192.0.2.53", "A", "example.com", shared...) },
+		func() {
+			c.AddDNSRecordSetRule("192.0.2.53", "NS", "example.com", []string{"ns1.example.com.", "ns2.example.com."})
+		},
+		func() { c.AddPingRule("192.0.2.1", shared...) },
+		func() { c.AddSMTPRule("mail.example.com:25") },
+		func() { c.AddSSHRule("git.example.com:22", "SSH-2.0-") },
+		func() {
+			c.DualStack(func(os ...*Option) { c.AddSimpleRule("https://dual.example.com/", os...) })
+		},
+	}
+	for j := i; j < len(rules); j += n {
+		rules[j]()
+	}
+}
+
+func marshalAll(t *testing.T, c *Config) string {
+	t.Helper()
+	var out []string
+	for _, f := range []func() ([]byte, error){c.Marshal, c.Targets.Marshal, c.Targets.MarshalRules, c.Targets.MarshalFileSD} {
+		b, err := f()
+		if err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, string(b))
+	}
+	return strings.Join(out, "\n---\n")
+}
+
+func TestConcurrentRules(t *testing.T) {
+	shared := []*Option{Timeout(3 * time.Second), Label("team", "web")}
+
+	want := newTestConfig()
+	addTestRules(want, 0, 1, shared)
+
+	const n = 4
+	var wg sync.WaitGroup
+	got := newTestConfig()
+	for i := range n {
+		wg.Go(func() { addTestRules(got, i, n, shared) })
+	}
+	// Build another config at the same time, to check that they don't
+	// share state.
+	other := newTestConfig()
+	wg.Go(func() { addTestRules(other, 0, 1, shared) })
+	wg.Wait()
+
+	if len(got.Targets.Targets) != len(want.Targets.Targets) {
+		t.Fatalf("got %d targets, want %d", len(got.Targets.Targets), len(want.Targets.Targets))
+	}
+	if g, w := marshalAll(t, got), marshalAll(t, want); g != w {
+		t.Errorf("concurrently built config differs:\ngot:\n%s\nwant:\n%s", g, w)
+	}
+	if g, w := marshalAll(t, other), marshalAll(t, want); g != w {
+		t.Errorf("config built alongside another differs:\ngot:\n%s\nwant:\n%s", g, w)
+	}
+}
+
+func TestMarshalDoesNotReorderTargets(t *testing.T) {
+	c := newTestConfig()
+	c.AddSimpleRule("https://b.example.com/")
+	c.AddSimpleRule("https://a.example.com/")
+	before := slices.Clone(c.Targets.Targets)
+
+	marshalAll(t, c)
+	if _, err := c.Targets.MarshalProbes(""); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.EqualFunc(before, c.Targets.Targets, func(a, b Target) bool { return a.Destination == b.Destination }) {
+		t.Errorf("marshaling reordered targets: %v", c.Targets.Targets)
+	}
+}
+
+func TestSourceComments(t *testing.T) {
+	c := newTestConfig()
+	_, _, line, _ := runtime.Caller(0)
+	c.AddSimpleRule("https://example.com/old", Status(301), NoFollowRedirects())
+	c.DualStack(func(os ...*Option) { c.AddPingRule("192.0.2.1", os...) })
+
+	bs, err := c.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sc, err := c.Targets.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name, got string
+		want      []string
+	}{
+		{"modules", string(bs), []string{
+			fmt.Sprintf("    # Status([301]) NoFollowRedirects()\n    # Added by config_test.go:%d.\n    mod_", line+1),
+			fmt.Sprintf("    # IPProtocol(ip6) IPFallback(false)\n    # Added by config_test.go:%d.\n    icmp_ip6:", line+2),
+		}},
+		{"targets", string(sc), []string{
+			fmt.Sprintf("    # Added by config_test.go:%d.\n    - \"https://example.com/old\"", line+1),
+			fmt.Sprintf("    # Added by config_test.go:%d.\n    - \"192.0.2.1\"", line+2),
+		}},
+	} {
+		for _, w := range tc.want {
+			if !strings.Contains(tc.got, w) {
+				t.Errorf("%s: missing %q in:\n%s", tc.name, w, tc.got)
+			}
+		}
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Validate failed: %v", err)
+	}
+}
+
+func TestTCPModuleComment(t *testing.T) {
+	c := newTestConfig()
+	c.AddSMTPStartTLSRule("mail.example.com:25")
+
+	bs, err := c.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(bs), "PANIC") || strings.Contains(string(bs), "%!") {
+		t.Errorf("bad formatting in:\n%s", bs)
+	}
+	want := `    # tcp module expect "^220[ -]([^ ]+) ESMTP(.+)?$", send "EHLO prober\r", expect "^250[ -]STARTTLS", send "STARTTLS\r", expect "^220", starttls, send "EHLO prober\r", expect "^250 ", send "QUIT\r", expect "^221 "` + "\n"
+	if !strings.Contains(string(bs), want) {
+		t.Errorf("missing TCP steps in:\n%s", bs)
+	}
+}
@@ -0,0 +1,141 @@
+package blackbox
+
+/*
+Copyright 2026 Robert Spier
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// TargetGroup is a Prometheus file_sd target group.
+type TargetGroup struct {
+	Targets []string          `json:"targets"`
+	Labels  map[string]string `json:"labels,omitempty"`
+
+	// sources are the sources of Targets, for comments.
+	sources []string
+}
+
+// groupTargets puts targets with the same labels into the same group.
+func groupTargets(targets []Target, labels func(Target) map[string]string) []TargetGroup {
+	var keys []string
+	groups := make(map[string]*TargetGroup)
+	for _, t := range targets {
+		ls := labels(t)
+
+		// json.Marshal sorts map keys, so this is a stable key.
+		kb, _ := json.Marshal(ls)
+		k := string(kb)
+		g, ok := groups[k]
+		if !ok {
+			g = &TargetGroup{Labels: ls}
+			groups[k] = g
+			keys = append(keys, k)
+		}
+		g.Targets = append(g.Targets, t.Destination)
+		g.sources = append(g.sources, t.source)
+	}
+	sort.Strings(keys)
+
+	out := make([]TargetGroup, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, *groups[k])
+	}
+	return out
+}
+
+// defaultScrapeTimeout is Prometheus' default scrape timeout, in seconds.
+const defaultScrapeTimeout = 10
+
+// FileSDGroups returns the targets as file_sd target groups.  Targets with
+// the same labels share a group.  A target whose module has a Timeout
+// carries the scrape timeout it needs in the __scrape_timeout__ label.  A
+// target with its own ScrapeInterval carries it in __scrape_interval__, and
+// if its module has no Timeout, a __scrape_timeout__ of Prometheus' default
+// or the interval, whichever is shorter, so Prometheus doesn't drop it.
+func (ts *Targets) FileSDGroups() []TargetGroup {
+	ts = ts.sorted()
+	return groupTargets(ts.Targets, func(t Target) map[string]string {
+		ls := ts.scrapeLabels(t)
+		if t.ScrapeInterval != 0 && t.ScrapeInterval != ts.ScrapeInterval {
+			ls["__scrape_interval__"] = strconv.Itoa(t.ScrapeInterval) + "s"
+			if _, ok := ls["__scrape_timeout__"]; !ok {
+				ls["__scrape_timeout__"] = strconv.Itoa(min(defaultScrapeTimeout, t.ScrapeInterval)) + "s"
+			}
+		}
+		return ls
+	})
+}
+
+// MarshalFileSD returns the targets as a file_sd JSON file.
+func (ts *Targets) MarshalFileSD() ([]byte, error) {
+	if err := ts.validate(); err != nil {
+		return nil, err
+	}
+	b, err := json.MarshalIndent(ts.FileSDGroups(), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+var fileSDCfgTmpl = `- job_name: '{{ .JobName }}'
+  scrape_interval: {{ .ScrapeInterval }}s
+  metrics_path: /probe
+  file_sd_configs:
+  - files:
+    - {{ str .File }}
+{{ template "relabel" . }}`
+
+var fileSDTmpl = newTemplate("filesd", fileSDCfgTmpl)
+
+// MarshalFileSDSC returns a scrape config that reads its targets from the
+// file_sd file at path, as written by MarshalFileSD.
+func (ts *Targets) MarshalFileSDSC(path string) ([]byte, error) {
+	var b bytes.Buffer
+	err := fileSDTmpl.Execute(&b, struct {
+		JobName          string
+		ScrapeInterval   int
+		File             string
+		BlackboxHostPort string
+	}{
+		JobName:          ts.JobName,
+		ScrapeInterval:   ts.ScrapeInterval,
+		File:             path,
+		BlackboxHostPort: ts.BlackboxHostPort,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("file_sd scrape config: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// MarshalFileSDConfig is like MarshalFileSDSC, but includes the same
+// prometheus.yaml header as Marshal.
+func (ts *Targets) MarshalFileSDConfig(path string) ([]byte, error) {
+	sc, err := ts.MarshalFileSDSC(path)
+	if err != nil {
+		return nil, err
+	}
+	var b bytes.Buffer
+	b.WriteString(header)
+	b.Write(sc)
+	return b.Bytes(), nil
+}
@@ -0,0 +1,150 @@
+package blackbox
+
+/*
+Copyright 2026 Robert Spier
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// testSource matches the source locations of rules added by tests, which
+// move whenever the tests are edited.
+var testSource = regexp.MustCompile(`(_test\.go):\d+`)
+
+// checkGolden compares got against testdata/name, rewriting it with -update.
+func checkGolden(t *testing.T, name string, got []byte) {
+	t.Helper()
+	goldenPath := filepath.Join("testdata", name)
+	got = testSource.ReplaceAll(got, []byte("$1:N"))
+
+	if *update {
+		if err := os.WriteFile(goldenPath, got, 0644); err != nil {
+			t.Fatalf("failed to write golden file: %v", err)
+		}
+	}
+
+	want, err := os.ReadFile(goldenPath)
+	if err != nil {
+		t.Fatalf("failed to read golden file (run with -update to generate): %v", err)
+	}
+
+	if string(got) != string(want) {
+		t.Errorf("%s mismatch\ngot:\n%s\nwant:\n%s", name, string(got), string(want))
+	}
+}
+
+func fileSDTestTargets() *Targets {
+	ts := &Targets{
+		JobName:          "test_job",
+		BlackboxHostPort: "localhost:9115",
+		ScrapeInterval:   30,
+	}
+
+	m1 := &Module{Name: "http_200", Module: BaseHTTPModule(200)}
+	m2 := &Module{Name: "redir_to_https_example_com", Module: BaseHTTPModule(302)}
+
+	ts.Add(m1, "https://example.com", "example.com")
+	ts.Add(m2, "http://example.com", "redir_to_https_example_com")
+	ts.Add(m1, "https://slow.example.com", "slow.example.com", ScrapeInterval(60))
+	ts.Add(m1, "https://www.example.com", "example.com")
+	return ts
+}
+
+func TestMarshalFileSDGolden(t *testing.T) {
+	ts := fileSDTestTargets()
+
+	got, err := ts.MarshalFileSD()
+	if err != nil {
+		t.Fatalf("MarshalFileSD failed: %v", err)
+	}
+	checkGolden(t, "filesd_marshal.golden", got)
+
+	sc, err := ts.MarshalFileSDConfig("targets.json")
+	if err != nil {
+		t.Fatalf("MarshalFileSDConfig failed: %v", err)
+	}
+	checkGolden(t, "filesd_sc_marshal.golden", sc)
+}
+
+func TestFileSDGroups(t *testing.T) {
+	gs := fileSDTestTargets().FileSDGroups()
+	if len(gs) != 3 {
+		t.Fatalf("expected 3 target groups, got %d: %+v", len(gs), gs)
+	}
+	for _, g := range gs {
+		if g.Labels["name"] == "example.com" && len(g.Targets) != 2 {
+			t.Errorf("expected targets with identical labels to share a group, got %+v", g)
+		}
+		if g.Labels["name"] == "slow.example.com" && (g.Labels["__scrape_interval__"] != "60s" || g.Labels["__scrape_timeout__"] != "10s") {
+			t.Errorf("expected __scrape_interval__ label of 60s and __scrape_timeout__ of 10s, got %+v", g)
+		}
+	}
+}
+
+func TestFileSDGroupsShortInterval(t *testing.T) {
+	ts := fileSDTestTargets()
+	m := &Module{Name: "http_200", Module: BaseHTTPModule(200)}
+	ts.Add(m, "https://fast.example.com", "fast.example.com", ScrapeInterval(5))
+
+	for _, g := range ts.FileSDGroups() {
+		if g.Labels["name"] != "fast.example.com" {
+			continue
+		}
+		if g.Labels["__scrape_interval__"] != "5s" || g.Labels["__scrape_timeout__"] != "5s" {
+			t.Errorf("expected __scrape_interval__ and __scrape_timeout__ labels of 5s, got %+v", g)
+		}
+		return
+	}
+	t.Error("no group for fast.example.com")
+}
+
+func TestRunFileSD(t *testing.T) {
+	dir := t.TempDir()
+	setFlag(t, "blackboxfile", filepath.Join(dir, "blackbox.yaml"))
+	setFlag(t, "targetsfile", filepath.Join(dir, "prometheus.yaml"))
+	setFlag(t, "filesdfile", filepath.Join(dir, "targets.json"))
+	setFlag(t, "targets_format", "file_sd")
+
+	err := Run(context.Background(), func(c *Config) {
+		c.AddSimpleRule("https://example.com")
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	sc, err := os.ReadFile(filepath.Join(dir, "prometheus.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(sc), "file_sd_configs") {
+		t.Errorf("expected scrape config to use file_sd_configs, got:\n%s", sc)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "targets.json")); err != nil {
+		t.Errorf("expected file_sd targets to be written: %v", err)
+	}
+}
+
+func TestRunUnknownTargetsFormat(t *testing.T) {
+	setFlag(t, "targets_format", "bogus")
+	if err := Run(context.Background(), func(c *Config) {}); err == nil {
+		t.Error("expected error for unknown --targets_format")
+	}
+}
@@ -0,0 +1,97 @@
+package blackbox
+
+/*
+Copyright 2026 Robert Spier
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Finding is a likely mistake in a Config, found by Lint.
+type Finding struct {
+	// Module is the module concerned, if any.
+	Module string
+	// Destination is the destination of the target concerned, if any.
+	Destination string
+	Message     string
+}
+
+func (f Finding) String() string {
+	switch {
+	case f.Destination != "":
+		return fmt.Sprintf("target %s (module %s): %s", f.Destination, f.Module, f.Message)
+	case f.Module != "":
+		return fmt.Sprintf("module %s: %s", f.Module, f.Message)
+	}
+	return f.Message
+}
+
+// Lint reports things that are valid, but probably not what was meant:
+// modules no target uses, targets of modules that don't exist, the same
+// module probing the same destination twice, targets scraped more often
+// than their module can time out, targets whose ModuleTimeout isn't their
+// module's Timeout, and HTTP targets without a scheme.
+// Targets are reported in output order.
+func (c *Config) Lint() []Finding {
+	var fs []Finding
+	used := make(map[string]bool)
+	seen := make(map[[2]string]bool)
+	for _, t := range c.Targets.sorted().Targets {
+		used[t.Module] = true
+		f := Finding{Module: t.Module, Destination: t.Destination}
+
+		k := [2]string{t.Module, t.Destination}
+		if seen[k] {
+			f.Message = "duplicate target"
+			fs = append(fs, f)
+		}
+		seen[k] = true
+
+		m, ok := c.Modules[t.Module]
+		if !ok {
+			f.Message = "unknown module"
+			fs = append(fs, f)
+			continue
+		}
+		si := t.ScrapeInterval
+		if si == 0 {
+			si = c.Targets.ScrapeInterval
+		}
+		if to := t.ModuleTimeout; si > 0 && to > time.Duration(si)*time.Second {
+			f.Message = fmt.Sprintf("scrape interval %ds is shorter than the module timeout %v", si, to)
+			fs = append(fs, f)
+		}
+		if t.ModuleTimeout != m.Module.Timeout {
+			f.Message = fmt.Sprintf("ModuleTimeout %v, but the module's Timeout is %v", t.ModuleTimeout, m.Module.Timeout)
+			fs = append(fs, f)
+		}
+		if m.Module.Prober == "http" && !strings.HasPrefix(t.Destination, "http://") && !strings.HasPrefix(t.Destination, "https://") {
+			f.Message = "HTTP target without http:// or https://"
+			fs = append(fs, f)
+		}
+	}
+
+	for _, n := range slices.Sorted(maps.Keys(c.Modules)) {
+		if !used[n] {
+			fs = append(fs, Finding{Module: n, Message: "not used by any target"})
+		}
+	}
+	return fs
+}
@@ -0,0 +1,76 @@
+package blackbox
+
+/*
+Copyright 2026 Robert Spier
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestLint(t *testing.T) {
+	c := newTestConfig()
+	c.Targets.ScrapeInterval = 30
+	c.AddSimpleRule("https://ok.example.com/")
+	c.AddSimpleRule("https://dup.example.com/")
+	c.AddSimpleRule("https://dup.example.com/")
+	c.AddSimpleRule("noscheme.example.com")
+	c.AddSMTPRule("mail.example.com:25", Timeout(time.Minute))
+	c.AddSSHRule("ssh.example.com:22", "SSH-2.0-", Timeout(time.Minute), ScrapeInterval(120))
+	c.Modules.Add(&Module{Name: "unused", Module: BaseTCPModule()})
+	c.Targets.Targets = append(c.Targets.Targets, Target{Module: "missing", Destination: "raw.example.com:80", Name: "raw"})
+
+	var got []string
+	for _, f := range c.Lint() {
+		got = append(got, f.String())
+	}
+	want := []string{
+		"target https://dup.example.com/ (module http_200): duplicate target",
+		"target mail.example.com:25 (module smtp): scrape interval 30s is shorter than the module timeout 1m0s",
+		"target noscheme.example.com (module http_200): HTTP target without http:// or https://",
+		"target raw.example.com:80 (module missing): unknown module",
+		"module unused: not used by any target",
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("Lint() =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestLintClean(t *testing.T) {
+	c := newTestConfig()
+	c.AddSimpleRuleWithRedirect("https://example.com/")
+	c.AddCertExpiryRule("https://example.com/", 14*24*time.Hour)
+	c.DualStack(func(os ...*Option) { c.AddPingRule("192.0.2.1", os...) })
+	if fs := c.Lint(); len(fs) != 0 {
+		t.Errorf("Lint() = %v; want no findings", fs)
+	}
+}
+
+func TestLintModuleTimeout(t *testing.T) {
+	c := newTestConfig()
+	c.AddSMTPRule("mail.example.com:25")
+	c.Targets.Targets = append(c.Targets.Targets, Target{Module: "smtp", Destination: "mail2.example.com:25", Name: "smtp"})
+
+	var got []string
+	for _, f := range c.Lint() {
+		got = append(got, f.String())
+	}
+	want := []string{"target mail2.example.com:25 (module smtp): ModuleTimeout 0s, but the module's Timeout is 5s"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Lint() =\n%q\nwant\n%q", got, want)
+	}
+}
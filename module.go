@@ -20,12 +20,17 @@ limitations under the License.
 import (
 	"crypto/sha1"
 	"fmt"
+	"maps"
+	"net/url"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/golang/glog"
+	"github.com/miekg/dns"
 	bbconfig "github.com/prometheus/blackbox_exporter/config"
+	promconfig "github.com/prometheus/common/config"
 	"gopkg.in/yaml.v3"
 )
 
@@ -34,6 +39,32 @@ type Module struct {
 	Description string
 	Module      *bbconfig.Module
 	HasOptions  bool
+
+	// nameSuffix is appended to Name once all options are applied.
+	nameSuffix string
+	// allowMissingHeaders is applied to all header matchers once all
+	// options are applied.
+	allowMissingHeaders bool
+	// errs are problems with options, reported by Config.Validate.
+	errs []error
+	// forcedName is set if Name() named the module.
+	forcedName bool
+	// baseName is the name the module was added with, before any hash
+	// suffix.
+	baseName string
+	// defaultHash is the hash of the module with just the defaults of the
+	// Add*Rule helper that made it.
+	defaultHash string
+	// noFallback is set if IPFallback(false) was applied.  Marshal writes
+	// ip_protocol_fallback: false only then, since base modules leave the
+	// field unset to get the exporter's default of true.
+	noFallback bool
+	// noRecursion is set if Recursion(false) was applied, for the same
+	// reason.
+	noRecursion bool
+	// source is where the rule that added the module was called, as
+	// "file.go:line".
+	source string
 }
 
 type ModuleMap map[string]*Module
@@ -43,7 +74,7 @@ func BaseHTTPModule(status int) *bbconfig.Module {
 		Prober: "http",
 		HTTP: bbconfig.HTTPProbe{
 			ValidStatusCodes: []int{status},
-			IPProtocol:       "ip4", // simpler to not worry about IPv6
+			IPProtocol:       "ip4", // see IPProtocol and Config.DualStack for IPv6
 		},
 	}
 	return c
@@ -81,7 +112,7 @@ func BaseDNSModule() *bbconfig.Module {
 	c := &bbconfig.Module{
 		Prober: "dns",
 		DNS: bbconfig.DNSProbe{
-			IPProtocol: "ip4", // simpler to not worry about IPv6
+			IPProtocol: "ip4", // see IPProtocol and Config.DualStack for IPv6
 		},
 	}
 	return c
@@ -103,20 +134,77 @@ func BaseTCPModule() *bbconfig.Module {
 	c := &bbconfig.Module{
 		Prober: "tcp",
 		TCP: bbconfig.TCPProbe{
-			IPProtocol: "ip4", // simpler to not worry about IPv6
+			IPProtocol: "ip4", // see IPProtocol and Config.DualStack for IPv6
 		},
 	}
 	return c
 
 }
 
+func BaseICMPModule() *bbconfig.Module {
+	c := &bbconfig.Module{
+		Prober: "icmp",
+		ICMP: bbconfig.ICMPProbe{
+			IPProtocol: "ip4", // see IPProtocol and Config.DualStack for IPv6
+		},
+	}
+	return c
+}
+
+func ICMPModule() *Module {
+	m := &Module{
+		Name:   "icmp",
+		Module: BaseICMPModule(),
+	}
+	return m
+}
+
+func BaseGRPCModule() *bbconfig.Module {
+	c := &bbconfig.Module{
+		Prober: "grpc",
+		GRPC: bbconfig.GRPCProbe{
+			PreferredIPProtocol: "ip4", // see IPProtocol and Config.DualStack for IPv6
+		},
+	}
+	return c
+}
+
+// GRPCModule checks the health of service, or of the whole server if
+// service is empty.
+func GRPCModule(service string) *Module {
+	bbm := BaseGRPCModule()
+	bbm.GRPC.Service = service
+
+	n := "grpc"
+	if service != "" {
+		n = cleanName("grpc_" + service)
+	}
+	m := &Module{
+		Name:        n,
+		Description: fmt.Sprintf("grpc health check for %q", service),
+		Module:      bbm,
+	}
+	return m
+}
+
+// formatQueryResponse describes the steps of qr, leaving out the parts of
+// each step that aren't set.
 func formatQueryResponse(qr []bbconfig.QueryResponse) string {
-	var b strings.Builder
+	var steps []string
 	for _, q := range qr {
-		b.WriteString(fmt.Sprintf("%q -> %q,", q.Send, q.Expect))
+		var parts []string
+		if q.Expect.Regexp != nil {
+			parts = append(parts, fmt.Sprintf("expect %q", q.Expect.String()))
+		}
+		if q.Send != "" {
+			parts = append(parts, fmt.Sprintf("send %q", q.Send))
+		}
+		if q.StartTLS {
+			parts = append(parts, "starttls")
+		}
+		steps = append(steps, strings.Join(parts, " "))
 	}
-	return b.String()
-
+	return strings.Join(steps, ", ")
 }
 
 func TCPModule(qr []bbconfig.QueryResponse) *Module {
@@ -130,32 +218,175 @@ func TCPModule(qr []bbconfig.QueryResponse) *Module {
 	return m
 }
 
+// checksCert reports whether m's probes report a TLS certificate.
+func (m *Module) checksCert() bool {
+	switch m.Module.Prober {
+	case "http":
+		return !m.Module.HTTP.FailIfSSL
+	case "tcp":
+		return m.Module.TCP.TLS
+	case "grpc":
+		return m.Module.GRPC.TLS
+	}
+	return false
+}
+
+// hash identifies the exporter configuration of m.  Modules that probe the
+// same way have the same hash, however their options were written.
 func (m Module) hash() string {
-	y, err := yaml.Marshal(m)
+	y, err := yaml.Marshal(m.Module)
 	if err != nil {
 		glog.Fatalf("can't Marshal Module: %v", err)
 	}
+	// IPFallback(false) and Recursion(false) leave the fields at their zero
+	// value, the same as base modules, so add what Marshal writes for them.
+	if m.noFallback {
+		y = append(y, "ip_protocol_fallback: false\n"...)
+	}
+	if m.noRecursion {
+		y = append(y, "recursion_desired: false\n"...)
+	}
 	h := sha1.Sum(y)
 	return fmt.Sprintf("%x", h[0:4])
 }
 
-var seq = 0
-
+// Add adds m to mm, naming it "mod_" and its hash if it has no name.
+//
+// Modules with the same settings share a name.  When modules with different
+// settings want the same name, only one keeps it, and the others get their
+// hash appended, whatever order they're added in.  A name set with Name() is
+// never changed, and using it for two different modules is an error,
+// reported by Config.Validate.  Otherwise the module an Add*Rule helper made
+// without options changing it keeps the name.  Modules that weren't made by
+// a helper keep a name they got first.  Add may rename a module already in mm
+// to settle a collision: the Add*Rule methods update their targets to match.
 func (mm ModuleMap) Add(m *Module) {
-	// If this Module isn't already named, give it one.
+	mm.add(m)
+}
+
+// add is Add, returning the new names of the modules it renamed.
+func (mm ModuleMap) add(m *Module) map[string]string {
 	h := m.hash()
 	if len(m.Name) == 0 {
 		m.Name = "mod_" + h
 	}
+	if m.baseName == "" {
+		m.baseName = m.Name
+	}
+	base := m.baseName
 
-	if existing, ok := mm[m.Name]; ok && m.HasOptions && existing.hash() != h {
-		seq++
-		m.Name += fmt.Sprintf("-%d", seq)
+	renamed := make(map[string]string)
+	group := []*Module{m}
+	for _, n := range slices.Sorted(maps.Keys(mm)) {
+		x := mm[n]
+		if x.baseName != base {
+			continue
+		}
+		if x.hash() == h {
+			// Save the Module in place of the one with the same settings,
+			// keeping the errors of both.
+			m.errs = mergeErrs(x.errs, m.errs)
+			if x.source != "" {
+				m.source = x.source
+			}
+			if m.forcedName && x.Name != base {
+				// Name() takes the name back from the modules that
+				// collided with x.
+				delete(mm, x.Name)
+				renamed[x.Name] = base
+				continue
+			}
+			m.Name = x.Name
+			m.forcedName = m.forcedName || x.forcedName
+			mm[m.Name] = m
+			return nil
+		}
+		if m.forcedName && x.forcedName {
+			x.errs = mergeErrs(x.errs, append(m.errs, fmt.Errorf("Name(%s): already used by a module with different settings", base)))
+			return nil
+		}
+		group = append(group, x)
 	}
 
-	// Save the Module.  It's possible this overwrites an existing one, but if
-	// the Name is the same, it should be the same
+	owner := nameOwner(group)
+	for _, x := range group {
+		n := base
+		if x != owner && len(group) > 1 {
+			n = base + "-" + x.hash()
+		}
+		if x == m {
+			m.Name = n
+			continue
+		}
+		if x.Name != n {
+			delete(mm, x.Name)
+			renamed[x.Name] = n
+			x.Name = n
+			mm[n] = x
+		}
+	}
+	if x, ok := mm[m.Name]; ok && x.baseName != base {
+		// A module added some other way has the name.
+		m.Name += "-" + h
+	}
 	mm[m.Name] = m
+	return renamed
+}
+
+// nameOwner returns the module of group that keeps the name they all want,
+// or nil if none does.  group[0] is the module being added.
+func nameOwner(group []*Module) *Module {
+	for _, x := range group {
+		if x.forcedName {
+			return x
+		}
+	}
+	var owner *Module
+	fromHelpers := false
+	for _, x := range group {
+		if x.defaultHash == "" {
+			continue
+		}
+		fromHelpers = true
+		if x.defaultHash == x.hash() {
+			if owner != nil {
+				return nil
+			}
+			owner = x
+		}
+	}
+	if fromHelpers {
+		return owner
+	}
+	for _, x := range group[1:] {
+		if x.Name == x.baseName {
+			return x
+		}
+	}
+	return nil
+}
+
+// comment describes m for a comment in the generated config.
+func (m *Module) comment() string {
+	var ls []string
+	if d := strings.TrimSpace(m.Description); d != "" {
+		ls = append(ls, d)
+	}
+	if m.source != "" {
+		ls = append(ls, "Added by "+m.source+".")
+	}
+	return strings.Join(ls, "\n")
+}
+
+// mergeErrs appends the errors in b that aren't already in a.
+func mergeErrs(a, b []error) []error {
+	out := slices.Clone(a)
+	for _, err := range b {
+		if !slices.ContainsFunc(out, func(e error) bool { return e.Error() == err.Error() }) {
+			out = append(out, err)
+		}
+	}
+	return out
 }
 
 func quoteMeta(s string) string {
@@ -173,12 +404,30 @@ func Status(s ...int) *Option {
 	}
 }
 
+// Name names the module.  Different modules can't share a name.
 func Name(n string) *Option {
 	return &Option{
 		ModuleOption: func(m *Module) {
-			n = strings.ReplaceAll(n, " ", "-")
+			m.Name = strings.ReplaceAll(n, " ", "-")
+			m.forcedName = true
+		}}
+}
+
+// defaults returns options naming the module n and applying os, for an
+// Add*Rule helper to put before the caller's options.  If modules with
+// different settings want the name n, ModuleMap.Add gives it to the one the
+// caller's options didn't change.
+func defaults(n string, os ...*Option) []*Option {
+	name := &Option{
+		ModuleOption: func(m *Module) {
 			m.Name = n
+			m.forcedName = false
+		}}
+	mark := &Option{
+		ModuleOption: func(m *Module) {
+			m.defaultHash = m.hash()
 		}}
+	return append(append([]*Option{name}, os...), mark)
 }
 
 func Contains(cs ...string) *Option {
@@ -194,6 +443,115 @@ func Contains(cs ...string) *Option {
 
 }
 
+// BodyMatches fails the probe unless the response body matches the regexp re.
+func BodyMatches(re string) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			opt := fmt.Sprintf("BodyMatches(%q)", re)
+			m.Description += opt + " "
+			if r, ok := m.newRegexp(opt, re); ok {
+				m.Module.HTTP.FailIfBodyNotMatchesRegexp = append(m.Module.HTTP.FailIfBodyNotMatchesRegexp, r)
+			}
+		}}
+}
+
+// BodyNotMatches fails the probe if the response body matches the regexp re.
+func BodyNotMatches(re string) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			opt := fmt.Sprintf("BodyNotMatches(%q)", re)
+			m.Description += opt + " "
+			if r, ok := m.newRegexp(opt, re); ok {
+				m.Module.HTTP.FailIfBodyMatchesRegexp = append(m.Module.HTTP.FailIfBodyMatchesRegexp, r)
+			}
+		}}
+}
+
+// newCELProgram compiles expr for the option opt, recording an error on m if
+// it's invalid.
+func (m *Module) newCELProgram(opt, expr string) (*bbconfig.CELProgram, bool) {
+	p, err := bbconfig.NewCELProgram(expr)
+	if err != nil {
+		m.errs = append(m.errs, fmt.Errorf("%s: %w", opt, err))
+		return nil, false
+	}
+	return &p, true
+}
+
+// JSONMatchesCEL fails the probe unless the response body is JSON for which
+// the CEL expression expr is true, e.g. `body.status == "ok"`.  Given more
+// than once, all expressions must be true.
+func JSONMatchesCEL(expr string) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			opt := fmt.Sprintf("JSONMatchesCEL(%q)", expr)
+			m.Description += opt + " "
+			e := expr
+			if prev := m.Module.HTTP.FailIfBodyJsonNotMatchesCEL; prev != nil {
+				e = fmt.Sprintf("(%s) && (%s)", prev.Expression, expr)
+			}
+			if p, ok := m.newCELProgram(opt, e); ok {
+				m.Module.HTTP.FailIfBodyJsonNotMatchesCEL = p
+			}
+		}}
+}
+
+// JSONNotMatchesCEL fails the probe if the response body is JSON for which
+// the CEL expression expr is true.  Given more than once, the probe fails if
+// any expression is true.
+func JSONNotMatchesCEL(expr string) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			opt := fmt.Sprintf("JSONNotMatchesCEL(%q)", expr)
+			m.Description += opt + " "
+			e := expr
+			if prev := m.Module.HTTP.FailIfBodyJsonMatchesCEL; prev != nil {
+				e = fmt.Sprintf("(%s) || (%s)", prev.Expression, expr)
+			}
+			if p, ok := m.newCELProgram(opt, e); ok {
+				m.Module.HTTP.FailIfBodyJsonMatchesCEL = p
+			}
+		}}
+}
+
+// HeaderMatches fails the probe unless response header h matches the regexp
+// re.  A missing header fails too, unless HeaderMissingOK is given.
+func HeaderMatches(h, re string) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			opt := fmt.Sprintf("HeaderMatches(%s, %q)", h, re)
+			m.Description += opt + " "
+			if r, ok := m.newRegexp(opt, re); ok {
+				m.Module.HTTP.FailIfHeaderNotMatchesRegexp = append(m.Module.HTTP.FailIfHeaderNotMatchesRegexp,
+					bbconfig.HeaderMatch{Header: h, Regexp: r})
+			}
+		}}
+}
+
+// HeaderNotMatches fails the probe if response header h matches the regexp
+// re.  A missing header fails too, unless HeaderMissingOK is given.
+func HeaderNotMatches(h, re string) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			opt := fmt.Sprintf("HeaderNotMatches(%s, %q)", h, re)
+			m.Description += opt + " "
+			if r, ok := m.newRegexp(opt, re); ok {
+				m.Module.HTTP.FailIfHeaderMatchesRegexp = append(m.Module.HTTP.FailIfHeaderMatchesRegexp,
+					bbconfig.HeaderMatch{Header: h, Regexp: r})
+			}
+		}}
+}
+
+// HeaderMissingOK lets the header matchers of HeaderMatches and
+// HeaderNotMatches pass when the header is missing.
+func HeaderMissingOK() *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += "HeaderMissingOK() "
+			m.allowMissingHeaders = true
+		}}
+}
+
 func NoFollowRedirects() *Option {
 	return &Option{
 		ModuleOption: func(m *Module) {
@@ -214,6 +572,66 @@ func Header(h, v string) *Option {
 	}
 }
 
+// Method sets the HTTP request method, e.g. "POST".
+func Method(method string) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += fmt.Sprintf("Method(%s) ", method)
+			m.Module.HTTP.Method = method
+		},
+	}
+}
+
+// Body sets the HTTP request body.
+func Body(b string) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += fmt.Sprintf("Body(%q) ", b)
+			m.Module.HTTP.Body = b
+		},
+	}
+}
+
+// BodyFile sets the HTTP request body to the contents of path, read by the
+// blackbox exporter.
+func BodyFile(path string) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += fmt.Sprintf("BodyFile(%s) ", path)
+			m.Module.HTTP.BodyFile = path
+		},
+	}
+}
+
+// BasicAuth authenticates HTTP requests as user, with the password read by
+// the blackbox exporter from passwordFile.  The password itself never
+// appears in the generated config.
+func BasicAuth(user, passwordFile string) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += fmt.Sprintf("BasicAuth(%s, %s) ", user, passwordFile)
+			m.Module.HTTP.HTTPClientConfig.BasicAuth = &promconfig.BasicAuth{
+				Username:     user,
+				PasswordFile: passwordFile,
+			}
+		},
+	}
+}
+
+// BearerTokenFile authenticates HTTP requests with a bearer token read by the
+// blackbox exporter from path.
+func BearerTokenFile(path string) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += fmt.Sprintf("BearerTokenFile(%s) ", path)
+			m.Module.HTTP.HTTPClientConfig.Authorization = &promconfig.Authorization{
+				Type:            "Bearer",
+				CredentialsFile: path,
+			}
+		},
+	}
+}
+
 func DNSAnswerFailIfMatchesRegexp(ms ...string) *Option {
 	return &Option{
 		ModuleOption: func(m *Module) {
@@ -230,6 +648,17 @@ func DNSAnswerFailIfNotMatchesRegexp(ms ...string) *Option {
 	}
 }
 
+// dnsAnswerFailIfNoneMatchesRegexp fails the probe unless an answer matches
+// re.  The exporter accepts a match for any of several regexps, so only one
+// is useful.
+func dnsAnswerFailIfNoneMatchesRegexp(re string) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Module.DNS.ValidateAnswer.FailIfNoneMatchesRegexp = []string{re}
+		},
+	}
+}
+
 func DNSAuthorityFailIfMatchesRegexp(ms ...string) *Option {
 	return &Option{
 		ModuleOption: func(m *Module) {
@@ -246,6 +675,93 @@ func DNSAuthorityFailIfNotMatchesRegexp(ms ...string) *Option {
 	}
 }
 
+// DNSAdditionalFailIfMatchesRegexp fails the probe if any record in the
+// additional section matches one of ms.
+func DNSAdditionalFailIfMatchesRegexp(ms ...string) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Module.DNS.ValidateAdditional.FailIfMatchesRegexp = ms
+		},
+	}
+}
+
+// DNSAdditionalFailIfNotMatchesRegexp fails the probe if any record in the
+// additional section doesn't match one of ms, or if the section is empty.
+func DNSAdditionalFailIfNotMatchesRegexp(ms ...string) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Module.DNS.ValidateAdditional.FailIfNotMatchesRegexp = ms
+		},
+	}
+}
+
+// dnsProbe returns the DNS settings of m, recording an error for the option
+// opt if m isn't a DNS module.
+func (m *Module) dnsProbe(opt string) *bbconfig.DNSProbe {
+	if m.Module.Prober != "dns" {
+		m.errs = append(m.errs, fmt.Errorf("%s: not supported by the %s prober", opt, m.Module.Prober))
+		return &bbconfig.DNSProbe{}
+	}
+	return &m.Module.DNS
+}
+
+// TransportProtocol sends DNS queries over "udp" (the default) or "tcp".
+func TransportProtocol(p string) *Option {
+	opt := fmt.Sprintf("TransportProtocol(%s)", p)
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += opt + " "
+			if p != "udp" && p != "tcp" {
+				m.errs = append(m.errs, fmt.Errorf("%s: want udp or tcp", opt))
+				return
+			}
+			m.dnsProbe(opt).TransportProtocol = p
+		},
+	}
+}
+
+// DNSOverTLS sends DNS queries over TLS, to port 853 unless the target has a
+// port.  Use TLSConfig or the TLS* options to configure it.
+func DNSOverTLS() *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += "DNSOverTLS() "
+			d := m.dnsProbe("DNSOverTLS()")
+			d.DNSOverTLS = true
+			d.TransportProtocol = "tcp"
+		},
+	}
+}
+
+// Recursion sets the recursion desired bit of DNS queries.  It's set by
+// default; use Recursion(false) to check an authoritative server.
+func Recursion(b bool) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += fmt.Sprintf("Recursion(%t) ", b)
+			m.noRecursion = !b
+			m.dnsProbe(fmt.Sprintf("Recursion(%t)", b)).Recursion = b
+		},
+	}
+}
+
+// ValidRcodes sets the response codes, like "NOERROR" or "NXDOMAIN", that a
+// DNS probe accepts.  The default is NOERROR.
+func ValidRcodes(rcodes ...string) *Option {
+	opt := fmt.Sprintf("ValidRcodes(%v)", rcodes)
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += opt + " "
+			for _, rc := range rcodes {
+				if _, ok := dns.StringToRcode[rc]; !ok {
+					m.errs = append(m.errs, fmt.Errorf("%s: unknown rcode %q", opt, rc))
+				}
+			}
+			m.dnsProbe(opt).ValidRcodes = rcodes
+		},
+	}
+}
+
 func TCPUseTLS() *Option {
 	return &Option{
 		ModuleOption: func(m *Module) {
@@ -255,6 +771,126 @@ func TCPUseTLS() *Option {
 	}
 }
 
+func GRPCUseTLS() *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Module.GRPC.TLS = true
+			m.Name = m.Name + "_tls"
+		},
+	}
+}
+
+// tlsConfig returns the TLS client configuration of m's prober, recording an
+// error for the option opt if the prober has none.
+func (m *Module) tlsConfig(opt string) *promconfig.TLSConfig {
+	switch m.Module.Prober {
+	case "http":
+		return &m.Module.HTTP.HTTPClientConfig.TLSConfig
+	case "tcp":
+		return &m.Module.TCP.TLSConfig
+	case "grpc":
+		return &m.Module.GRPC.TLSConfig
+	case "dns":
+		return &m.Module.DNS.TLSConfig
+	}
+	m.errs = append(m.errs, fmt.Errorf("%s: not supported by the %s prober", opt, m.Module.Prober))
+	return &promconfig.TLSConfig{}
+}
+
+// tlsOption returns an Option that calls f with the TLS client configuration
+// of the module's prober.
+func tlsOption(opt string, f func(tc *promconfig.TLSConfig)) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += opt + " "
+			f(m.tlsConfig(opt))
+		},
+	}
+}
+
+// TLSConfig sets the whole TLS client configuration of an HTTP, TCP, gRPC or
+// DNS probe.
+func TLSConfig(tc promconfig.TLSConfig) *Option {
+	return tlsOption("TLSConfig()", func(c *promconfig.TLSConfig) {
+		*c = tc
+	})
+}
+
+// TLSCAFile verifies the server certificate against the CAs in path.
+func TLSCAFile(path string) *Option {
+	return tlsOption(fmt.Sprintf("TLSCAFile(%s)", path), func(c *promconfig.TLSConfig) {
+		c.CAFile = path
+	})
+}
+
+// TLSClientCert presents the client certificate in certFile, with its key in
+// keyFile.
+func TLSClientCert(certFile, keyFile string) *Option {
+	return tlsOption(fmt.Sprintf("TLSClientCert(%s, %s)", certFile, keyFile), func(c *promconfig.TLSConfig) {
+		c.CertFile = certFile
+		c.KeyFile = keyFile
+	})
+}
+
+// TLSServerName sets the name used for SNI and to verify the server
+// certificate.
+func TLSServerName(name string) *Option {
+	return tlsOption(fmt.Sprintf("TLSServerName(%s)", name), func(c *promconfig.TLSConfig) {
+		c.ServerName = name
+	})
+}
+
+// TLSMinVersion sets the minimum TLS version: "TLS10", "TLS11", "TLS12" or
+// "TLS13".
+func TLSMinVersion(v string) *Option {
+	opt := fmt.Sprintf("TLSMinVersion(%s)", v)
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += opt + " "
+			tv, ok := promconfig.TLSVersions[v]
+			if !ok {
+				m.errs = append(m.errs, fmt.Errorf("%s: unknown TLS version", opt))
+				return
+			}
+			m.tlsConfig(opt).MinVersion = tv
+		},
+	}
+}
+
+// TLSInsecureSkipVerify doesn't verify the server certificate.
+func TLSInsecureSkipVerify() *Option {
+	return tlsOption("TLSInsecureSkipVerify()", func(c *promconfig.TLSConfig) {
+		c.InsecureSkipVerify = true
+	})
+}
+
+// FailIfSSL fails an HTTP probe if the connection uses TLS.
+func FailIfSSL() *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += "FailIfSSL() "
+			if m.Module.Prober != "http" {
+				m.errs = append(m.errs, fmt.Errorf("FailIfSSL(): not supported by the %s prober", m.Module.Prober))
+			}
+			m.Module.HTTP.FailIfSSL = true
+		},
+	}
+}
+
+// FailIfNotSSL fails an HTTP probe unless the connection uses TLS.  Use
+// TCPUseTLS for TCP probes.
+func FailIfNotSSL() *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += "FailIfNotSSL() "
+			if m.Module.Prober != "http" {
+				m.errs = append(m.errs, fmt.Errorf("FailIfNotSSL(): not supported by the %s prober", m.Module.Prober))
+			}
+			m.Module.HTTP.FailIfNotSSL = true
+		},
+	}
+}
+
 func Timeout(t time.Duration) *Option {
 	return &Option{
 		ModuleOption: func(m *Module) {
@@ -263,6 +899,176 @@ func Timeout(t time.Duration) *Option {
 	}
 }
 
+// PayloadSize sets the size of the ICMP echo payload.
+func PayloadSize(n int) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += fmt.Sprintf("PayloadSize(%d) ", n)
+			m.Module.ICMP.PayloadSize = n
+		},
+	}
+}
+
+// DontFragment sets the DF bit on ICMP echo requests.
+func DontFragment() *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += "DontFragment() "
+			m.Module.ICMP.DontFragment = true
+		},
+	}
+}
+
+// TTL sets the TTL of ICMP echo requests.
+func TTL(ttl int) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += fmt.Sprintf("TTL(%d) ", ttl)
+			m.Module.ICMP.TTL = ttl
+		},
+	}
+}
+
+// SourceIPAddress sets the address probes are sent from.
+func SourceIPAddress(ip string) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += fmt.Sprintf("SourceIPAddress(%s) ", ip)
+			switch m.Module.Prober {
+			case "icmp":
+				m.Module.ICMP.SourceIPAddress = ip
+			case "tcp":
+				m.Module.TCP.SourceIPAddress = ip
+			case "dns":
+				m.Module.DNS.SourceIPAddress = ip
+			default:
+				m.errs = append(m.errs, fmt.Errorf("SourceIPAddress(%s): not supported by the %s prober", ip, m.Module.Prober))
+			}
+		},
+	}
+}
+
+// proxyConfig returns the proxy configuration of an HTTP module, recording an
+// error for the option opt for other probers.
+func (m *Module) proxyConfig(opt string) *promconfig.ProxyConfig {
+	if m.Module.Prober != "http" {
+		m.errs = append(m.errs, fmt.Errorf("%s: not supported by the %s prober", opt, m.Module.Prober))
+		return &promconfig.ProxyConfig{}
+	}
+	return &m.Module.HTTP.HTTPClientConfig.ProxyConfig
+}
+
+// ProxyURL sends HTTP probes through the proxy at u.  The generated config
+// would redact a password, so put credentials in the environment and use
+// ProxyFromEnvironment instead.
+func ProxyURL(u string) *Option {
+	opt := fmt.Sprintf("ProxyURL(%s)", u)
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += opt + " "
+			pu, err := url.Parse(u)
+			if err != nil {
+				m.errs = append(m.errs, fmt.Errorf("%s: %w", opt, err))
+				return
+			}
+			if _, ok := pu.User.Password(); ok {
+				m.errs = append(m.errs, fmt.Errorf("%s: proxy passwords are not supported", opt))
+				return
+			}
+			m.proxyConfig(opt).ProxyURL = promconfig.URL{URL: pu}
+		},
+	}
+}
+
+// NoProxy lists hosts, domains, IPs or CIDRs that bypass the ProxyURL.
+func NoProxy(hosts ...string) *Option {
+	opt := fmt.Sprintf("NoProxy(%v)", hosts)
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += opt + " "
+			pc := m.proxyConfig(opt)
+			np := strings.Join(hosts, ",")
+			if pc.NoProxy != "" {
+				np = pc.NoProxy + "," + np
+			}
+			pc.NoProxy = np
+		},
+	}
+}
+
+// ProxyFromEnvironment uses the exporter's HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables to choose a proxy for HTTP probes.
+func ProxyFromEnvironment() *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += "ProxyFromEnvironment() "
+			m.proxyConfig("ProxyFromEnvironment()").ProxyFromEnvironment = true
+		},
+	}
+}
+
+// IPProtocol sets the preferred IP protocol, "ip4" or "ip6", and suffixes
+// the module name with it.
+func IPProtocol(p string) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += fmt.Sprintf("IPProtocol(%s) ", p)
+			m.nameSuffix = "_" + p
+			switch m.Module.Prober {
+			case "http":
+				m.Module.HTTP.IPProtocol = p
+			case "tcp":
+				m.Module.TCP.IPProtocol = p
+			case "dns":
+				m.Module.DNS.IPProtocol = p
+			case "icmp":
+				m.Module.ICMP.IPProtocol = p
+			case "grpc":
+				m.Module.GRPC.PreferredIPProtocol = p
+			}
+		},
+	}
+}
+
+// IPFallback sets whether the probe may fall back to the other IP protocol.
+func IPFallback(b bool) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.Description += fmt.Sprintf("IPFallback(%v) ", b)
+			m.noFallback = !b
+			switch m.Module.Prober {
+			case "http":
+				m.Module.HTTP.IPProtocolFallback = b
+			case "tcp":
+				m.Module.TCP.IPProtocolFallback = b
+			case "dns":
+				m.Module.DNS.IPProtocolFallback = b
+			case "icmp":
+				m.Module.ICMP.IPProtocolFallback = b
+			case "grpc":
+				m.Module.GRPC.IPProtocolFallback = b
+			}
+		},
+	}
+}
+
+// ipFallback returns the ip_protocol_fallback setting of m's prober.
+func ipFallback(m *bbconfig.Module) bool {
+	switch m.Prober {
+	case "http":
+		return m.HTTP.IPProtocolFallback
+	case "tcp":
+		return m.TCP.IPProtocolFallback
+	case "dns":
+		return m.DNS.IPProtocolFallback
+	case "icmp":
+		return m.ICMP.IPProtocolFallback
+	case "grpc":
+		return m.GRPC.IPProtocolFallback
+	}
+	return true
+}
+
 func CustomFunc(f func(*bbconfig.Module)) *Option {
 	return &Option{
 		ModuleOption: func(m *Module) {
@@ -282,7 +1088,41 @@ func (m *Module) applyOptions(os ...*Option) {
 				m.Name = ""
 				m.HasOptions = true
 			}
+			if m.Description != "" && !strings.HasSuffix(m.Description, " ") {
+				m.Description += " "
+			}
 		})
 		o.ModuleOption(m)
 	}
+	if m.Name != "" {
+		m.Name += m.nameSuffix
+	}
+	if m.allowMissingHeaders {
+		for i := range m.Module.HTTP.FailIfHeaderMatchesRegexp {
+			m.Module.HTTP.FailIfHeaderMatchesRegexp[i].AllowMissing = true
+		}
+		for i := range m.Module.HTTP.FailIfHeaderNotMatchesRegexp {
+			m.Module.HTTP.FailIfHeaderNotMatchesRegexp[i].AllowMissing = true
+		}
+	}
+}
+
+// optionErr records err on the module, for a rule whose arguments are
+// invalid.
+func optionErr(err error) *Option {
+	return &Option{
+		ModuleOption: func(m *Module) {
+			m.errs = append(m.errs, err)
+		}}
+}
+
+// newRegexp compiles re for the option opt, recording an error on m instead
+// of panicking if it's invalid.
+func (m *Module) newRegexp(opt, re string) (bbconfig.Regexp, bool) {
+	r, err := bbconfig.NewRegexp(re)
+	if err != nil {
+		m.errs = append(m.errs, fmt.Errorf("%s: %w", opt, err))
+		return r, false
+	}
+	return r, true
 }
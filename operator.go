@@ -0,0 +1,232 @@
+package blackbox
+
+/*
+Copyright 2026 Robert Spier
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// These types mirror just enough of the Prometheus Operator and Kubernetes
+// APIs to render Probe and ConfigMap objects, without depending on either.
+
+type objectMeta struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+type probe struct {
+	APIVersion string     `yaml:"apiVersion"`
+	Kind       string     `yaml:"kind"`
+	Metadata   objectMeta `yaml:"metadata"`
+	Spec       probeSpec  `yaml:"spec"`
+}
+
+type probeSpec struct {
+	JobName       string       `yaml:"jobName,omitempty"`
+	Interval      string       `yaml:"interval,omitempty"`
+	ScrapeTimeout string       `yaml:"scrapeTimeout,omitempty"`
+	Module        string       `yaml:"module"`
+	Prober        prober       `yaml:"prober"`
+	Targets       probeTargets `yaml:"targets"`
+}
+
+type prober struct {
+	URL  string `yaml:"url"`
+	Path string `yaml:"path,omitempty"`
+}
+
+type probeTargets struct {
+	StaticConfig probeStaticConfig `yaml:"staticConfig"`
+}
+
+type probeStaticConfig struct {
+	Static            []string          `yaml:"static"`
+	Labels            map[string]string `yaml:"labels,omitempty"`
+	RelabelingConfigs []relabelConfig   `yaml:"relabelingConfigs,omitempty"`
+}
+
+type relabelConfig struct {
+	SourceLabels []string `yaml:"sourceLabels,omitempty"`
+	Regex        string   `yaml:"regex,omitempty"`
+	TargetLabel  string   `yaml:"targetLabel,omitempty"`
+	Replacement  string   `yaml:"replacement,omitempty"`
+}
+
+type configMap struct {
+	APIVersion string            `yaml:"apiVersion"`
+	Kind       string            `yaml:"kind"`
+	Metadata   objectMeta        `yaml:"metadata"`
+	Data       map[string]string `yaml:"data,omitempty"`
+	StringData map[string]string `yaml:"stringData,omitempty"`
+}
+
+var k8sNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// k8sName turns s into a valid Kubernetes object name.
+func k8sName(s string) string {
+	s = k8sNameChars.ReplaceAllString(strings.ToLower(s), "-")
+	return strings.Trim(s, "-")
+}
+
+// marshalDocs renders objs as a multi-document YAML stream.
+func marshalDocs[T any](objs []T) ([]byte, error) {
+	var b bytes.Buffer
+	e := yaml.NewEncoder(&b)
+	e.SetIndent(2)
+	for _, o := range objs {
+		if err := e.Encode(o); err != nil {
+			return nil, err
+		}
+	}
+	if err := e.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// MarshalProbes returns a Prometheus Operator Probe custom resource for each
+// (module, scrape interval) group of targets.  The name and other labels are
+// attached to each target with relabelings on its address.  namespace may be
+// empty.  It's an error if two groups would get the same Probe name, e.g.
+// modules named "web_a" and "web-a".
+func (ts *Targets) MarshalProbes(namespace string) ([]byte, error) {
+	if err := ts.validate(); err != nil {
+		return nil, err
+	}
+	ts = ts.sorted()
+
+	type key struct {
+		module string
+		scrapeKey
+	}
+	groups := make(map[key][]Target)
+	var keys []key
+	for sk, tsi := range ts.byScrape() {
+		for _, t := range tsi {
+			k := key{t.Module, sk}
+			if _, ok := groups[k]; !ok {
+				keys = append(keys, k)
+			}
+			groups[k] = append(groups[k], t)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].module != keys[j].module {
+			return keys[i].module < keys[j].module
+		}
+		if keys[i].interval != keys[j].interval {
+			return keys[i].interval < keys[j].interval
+		}
+		return keys[i].timeout < keys[j].timeout
+	})
+
+	var probes []probe
+	var errs []error
+	names := make(map[string]key)
+	for _, k := range keys {
+		name := k8sName(fmt.Sprintf("%s-%s-%ds", ts.JobName, k.module, k.interval))
+		if prev, ok := names[name]; ok {
+			errs = append(errs, fmt.Errorf("Probe name %q is used for module %s (interval %ds, timeout %ds) and module %s (interval %ds, timeout %ds)",
+				name, prev.module, prev.interval, prev.timeout, k.module, k.interval, k.timeout))
+			continue
+		}
+		names[name] = k
+		p := probe{
+			APIVersion: "monitoring.coreos.com/v1",
+			Kind:       "Probe",
+			Metadata: objectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+			Spec: probeSpec{
+				JobName:  ts.JobName,
+				Interval: fmt.Sprintf("%ds", k.interval),
+				Module:   k.module,
+				Prober: prober{
+					URL:  ts.BlackboxHostPort,
+					Path: "/probe",
+				},
+				Targets: probeTargets{
+					StaticConfig: probeStaticConfig{
+						Labels: map[string]string{"module": k.module},
+					},
+				},
+			},
+		}
+		if k.timeout > 0 {
+			p.Spec.ScrapeTimeout = fmt.Sprintf("%ds", k.timeout)
+		}
+		sc := &p.Spec.Targets.StaticConfig
+		for _, t := range groups[k] {
+			sc.Static = append(sc.Static, t.Destination)
+			ls := t.labels()
+			delete(ls, "module")
+			for _, l := range slices.Sorted(maps.Keys(ls)) {
+				sc.RelabelingConfigs = append(sc.RelabelingConfigs, relabelConfig{
+					SourceLabels: []string{"__param_target"},
+					Regex:        regexp.QuoteMeta(t.Destination),
+					TargetLabel:  l,
+					Replacement:  strings.ReplaceAll(ls[l], "$", "$$"),
+				})
+			}
+		}
+		probes = append(probes, p)
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+
+	return marshalDocs(probes)
+}
+
+// MarshalConfigMap returns a Kubernetes ConfigMap named name holding the
+// output of Marshal under key.  namespace may be empty.
+func (c *Config) MarshalConfigMap(name, namespace, key string) ([]byte, error) {
+	bs, err := c.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return marshalDocs([]configMap{{
+		APIVersion: "v1",
+		Kind:       "ConfigMap",
+		Metadata:   objectMeta{Name: name, Namespace: namespace},
+		Data:       map[string]string{key: string(bs)},
+	}})
+}
+
+// MarshalSecret is like MarshalConfigMap, but returns a Secret.
+func (c *Config) MarshalSecret(name, namespace, key string) ([]byte, error) {
+	bs, err := c.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	return marshalDocs([]configMap{{
+		APIVersion: "v1",
+		Kind:       "Secret",
+		Metadata:   objectMeta{Name: name, Namespace: namespace},
+		StringData: map[string]string{key: string(bs)},
+	}})
+}
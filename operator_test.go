@@ -0,0 +1,114 @@
+package blackbox
+
+/*
+Copyright 2026 Robert Spier
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestK8sName(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"blackbox-http_200-30s", "blackbox-http-200-30s"},
+		{"Redir_To_HTTPS", "redir-to-https"},
+		{"_foo_", "foo"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.input, func(t *testing.T) {
+			got := k8sName(tc.input)
+			if got != tc.expected {
+				t.Errorf("k8sName(%q) = %q; want %q", tc.input, got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestMarshalProbesGolden(t *testing.T) {
+	got, err := fileSDTestTargets().MarshalProbes("monitoring")
+	if err != nil {
+		t.Fatalf("MarshalProbes failed: %v", err)
+	}
+	checkGolden(t, "probes_marshal.golden", got)
+}
+
+func TestMarshalConfigMap(t *testing.T) {
+	c := &Config{
+		Modules: make(ModuleMap),
+		Targets: &Targets{},
+	}
+	c.AddSimpleRule("https://example.com")
+
+	for _, tc := range []struct {
+		kind    string
+		marshal func(name, namespace, key string) ([]byte, error)
+		field   string
+	}{
+		{"ConfigMap", c.MarshalConfigMap, "data"},
+		{"Secret", c.MarshalSecret, "stringData"},
+	} {
+		t.Run(tc.kind, func(t *testing.T) {
+			got, err := tc.marshal("bb", "monitoring", "blackbox.yaml")
+			if err != nil {
+				t.Fatalf("marshal failed: %v", err)
+			}
+
+			var obj map[string]any
+			if err := yaml.Unmarshal(got, &obj); err != nil {
+				t.Fatalf("output is not valid YAML: %v", err)
+			}
+			if obj["kind"] != tc.kind {
+				t.Errorf("kind = %v; want %v", obj["kind"], tc.kind)
+			}
+			data, _ := obj[tc.field].(map[string]any)
+			bs, _ := data["blackbox.yaml"].(string)
+			if !strings.Contains(bs, "http_200:") {
+				t.Errorf("expected %s to contain the module config, got %v", tc.field, obj[tc.field])
+			}
+		})
+	}
+}
+
+func TestMarshalProbesLabels(t *testing.T) {
+	ts := &Targets{JobName: "test_job", ScrapeInterval: 30}
+	m := &Module{Name: "http_200", Module: BaseHTTPModule(200)}
+	ts.Add(m, "https://example.com", "example.com", Label("team", "web"))
+
+	got, err := ts.MarshalProbes("")
+	if err != nil {
+		t.Fatalf("MarshalProbes failed: %v", err)
+	}
+	if !strings.Contains(string(got), "targetLabel: team\n          replacement: web") {
+		t.Errorf("expected a relabeling for the team label, got:\n%s", got)
+	}
+}
+
+func TestMarshalProbesDuplicateNames(t *testing.T) {
+	ts := &Targets{JobName: "test_job", ScrapeInterval: 30}
+	ts.Add(&Module{Name: "web_a", Module: BaseHTTPModule(200)}, "https://a.example.com", "a")
+	ts.Add(&Module{Name: "web-a", Module: BaseHTTPModule(200)}, "https://b.example.com", "b")
+
+	_, err := ts.MarshalProbes("")
+	if err == nil || !strings.Contains(err.Error(), `Probe name "test-job-web-a-30s" is used for module web-a (interval 30s, timeout 0s) and module web_a (interval 30s, timeout 0s)`) {
+		t.Errorf("expected an error about the duplicate Probe name, got: %v", err)
+	}
+}
@@ -0,0 +1,222 @@
+package blackbox
+
+/*
+Copyright 2026 Robert Spier
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log/slog"
+	"math/big"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	bbconfig "github.com/prometheus/blackbox_exporter/config"
+	bbprober "github.com/prometheus/blackbox_exporter/prober"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v3"
+)
+
+// fakeConn is one client connection to a fake server.
+type fakeConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// send writes lines to the client, terminated by "\r\n".
+func (c *fakeConn) send(lines ...string) {
+	for _, l := range lines {
+		io.WriteString(c.Conn, l+"\r\n")
+	}
+}
+
+// recv reads one line from the client, without the line ending.
+func (c *fakeConn) recv() string {
+	l, _ := c.r.ReadString('\n')
+	return strings.TrimRight(l, "\r\n")
+}
+
+// startTLS upgrades the connection using cert.
+func (c *fakeConn) startTLS(cert tls.Certificate) {
+	tc := tls.Server(c.Conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+	c.Conn = tc
+	c.r = bufio.NewReader(tc)
+}
+
+// fakeServer runs handle for each connection to a local listener, and
+// returns its address.
+func fakeServer(t *testing.T, handle func(c *fakeConn)) string {
+	t.Helper()
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				conn.SetDeadline(time.Now().Add(5 * time.Second))
+				handle(&fakeConn{Conn: conn, r: bufio.NewReader(conn)})
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// fakeDNSServer serves handler over UDP and TCP on the same local port, and
+// over TLS on another if cert isn't nil.  It returns the addresses.
+func fakeDNSServer(t *testing.T, handler dns.HandlerFunc, cert *tls.Certificate) (addr, tlsAddr string) {
+	t.Helper()
+	pc, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr = pc.LocalAddr().String()
+	l, err := net.Listen("tcp4", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	servers := []*dns.Server{
+		{PacketConn: pc, Handler: handler},
+		{Listener: l, Handler: handler},
+	}
+	if cert != nil {
+		tl, err := tls.Listen("tcp4", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{*cert}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tlsAddr = tl.Addr().String()
+		servers = append(servers, &dns.Server{Listener: tl, Net: "tcp-tls", Handler: handler})
+	}
+	for _, s := range servers {
+		started := make(chan struct{})
+		s.NotifyStartedFunc = func() { close(started) }
+		go s.ActivateAndServe()
+		<-started
+		t.Cleanup(func() { s.Shutdown() })
+	}
+	return addr, tlsAddr
+}
+
+// testCert returns a self-signed certificate for 127.0.0.1, and its PEM.
+func testCert(t *testing.T) (tls.Certificate, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1)},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemCert := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pemCert
+}
+
+// runProbe runs the blackbox_exporter prober for the module used by c's only
+// target, as loaded from c's marshaled config.
+func runProbe(t *testing.T, c *Config, target string) bool {
+	t.Helper()
+	if len(c.Targets.Targets) != 1 {
+		t.Fatalf("expected exactly one target, got %d", len(c.Targets.Targets))
+	}
+	return probeModule(t, loadModules(t, c), c.Targets.Targets[0].Module, target)
+}
+
+// runProbes probes each of c's targets, and reports whether all succeeded.
+func runProbes(t *testing.T, c *Config) bool {
+	t.Helper()
+	mods := loadModules(t, c)
+	ok := true
+	for _, tg := range c.Targets.Targets {
+		if !probeModule(t, mods, tg.Module, tg.Destination) {
+			t.Logf("probe of %s with module %s failed", tg.Destination, tg.Module)
+			ok = false
+		}
+	}
+	return ok
+}
+
+// loadModules loads c's marshaled config the way the exporter does.
+func loadModules(t *testing.T, c *Config) map[string]bbconfig.Module {
+	t.Helper()
+	bs, err := c.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+	var bbc bbconfig.Config
+	if err := yaml.Unmarshal(bs, &bbc); err != nil {
+		t.Fatalf("exporter can't load config: %v", err)
+	}
+	return bbc.Modules
+}
+
+func probeModule(t *testing.T, mods map[string]bbconfig.Module, module, target string) bool {
+	t.Helper()
+	m, ok := mods[module]
+	if !ok {
+		t.Fatalf("module %q not in config", module)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	probers := map[string]bbprober.ProbeFn{
+		"http": bbprober.ProbeHTTP,
+		"tcp":  bbprober.ProbeTCP,
+		"dns":  bbprober.ProbeDNS,
+	}
+	pf, ok := probers[m.Prober]
+	if !ok {
+		t.Fatalf("no prober for %q", m.Prober)
+	}
+	return pf(ctx, target, m, prometheus.NewRegistry(), logger)
+}
+
+func newTestConfig() *Config {
+	return &Config{
+		Modules: make(ModuleMap),
+		Targets: &Targets{},
+	}
+}
@@ -0,0 +1,218 @@
+package blackbox
+
+/*
+Copyright 2026 Robert Spier
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Canned TCP dialogues for common services, in the style of AddSMTPRule.
+// Use TCPUseTLS() for the implicit TLS ports (pop3s, ldaps, ...).
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	bbconfig "github.com/prometheus/blackbox_exporter/config"
+)
+
+func (c *Config) AddPOP3Rule(server string, os ...*Option) {
+	os = append(defaults("pop3", Timeout(5*time.Second)), os...)
+	c.AddTCPRule(server,
+		[]bbconfig.QueryResponse{
+			bbconfig.QueryResponse{
+				Expect: bbconfig.MustNewRegexp(`^\+OK`),
+			},
+			bbconfig.QueryResponse{
+				Send: "QUIT\r",
+			},
+			bbconfig.QueryResponse{
+				Expect: bbconfig.MustNewRegexp(`^\+OK`),
+			},
+		},
+		os...)
+}
+
+// AddSSHRule checks for an SSH-2.0 banner.  If version is not empty, it is
+// a regexp the software version in the banner must match, e.g. "OpenSSH_9".
+func (c *Config) AddSSHRule(server, version string, os ...*Option) {
+	if version != "" {
+		v := &Option{
+			ModuleOption: func(m *Module) {
+				opt := fmt.Sprintf("AddSSHRule(%q)", version)
+				m.Description += opt + " "
+				if r, ok := m.newRegexp(opt, `^SSH-2\.0-`+version); ok {
+					m.Module.TCP.QueryResponse[0].Expect = r
+				}
+			}}
+		os = append([]*Option{v}, os...)
+	}
+	os = append(defaults("ssh", Timeout(5*time.Second)), os...)
+	c.AddTCPRule(server,
+		[]bbconfig.QueryResponse{
+			bbconfig.QueryResponse{
+				Expect: bbconfig.MustNewRegexp(`^SSH-2\.0-`),
+			},
+			bbconfig.QueryResponse{
+				// Identify ourselves so the server doesn't log a bogus
+				// client.
+				Send: "SSH-2.0-blackbox_exporter\r",
+			},
+		},
+		os...)
+}
+
+func (c *Config) AddFTPRule(server string, os ...*Option) {
+	os = append(defaults("ftp", Timeout(5*time.Second)), os...)
+	c.AddTCPRule(server,
+		[]bbconfig.QueryResponse{
+			bbconfig.QueryResponse{
+				// Skip over multi-line "220-" banners.
+				Expect: bbconfig.MustNewRegexp(`^220 `),
+			},
+			bbconfig.QueryResponse{
+				Send: "QUIT\r",
+			},
+			bbconfig.QueryResponse{
+				Expect: bbconfig.MustNewRegexp(`^221`),
+			},
+		},
+		os...)
+}
+
+// LDAP messages are BER encoded, but the prober appends a newline to
+// everything it sends.  So each message ends with a non-critical control
+// (OID 2.25.1, which servers ignore) whose one byte value is that newline.
+const (
+	// An anonymous simple bind, message ID 1.
+	ldapAnonymousBind = "\x30\x1b\x02\x01\x01\x60\x07\x02\x01\x03\x04\x00\x80\x00" +
+		"\xa0\x0d\x30\x0b\x04\x062.25.1\x04\x01"
+	// An unbind, message ID 2.
+	ldapUnbind = "\x30\x14\x02\x01\x02\x42\x00" +
+		"\xa0\x0d\x30\x0b\x04\x062.25.1\x04\x01"
+)
+
+// AddLDAPRule checks that the server accepts an anonymous bind.
+func (c *Config) AddLDAPRule(server string, os ...*Option) {
+	os = append(defaults("ldap", Timeout(5*time.Second)), os...)
+	c.AddTCPRule(server,
+		[]bbconfig.QueryResponse{
+			bbconfig.QueryResponse{
+				Send: ldapAnonymousBind,
+			},
+			bbconfig.QueryResponse{
+				// The prober reads lines, and the BindResponse's
+				// resultCode is an ENUMERATED (tag 0x0a, a newline), so
+				// this is everything up to the resultCode.
+				Expect: bbconfig.MustNewRegexp(`(?s)^0.*\x02\x01\x01a`),
+			},
+			bbconfig.QueryResponse{
+				// The server closes the connection, which ends the
+				// "line" with the resultCode.
+				Send: ldapUnbind,
+			},
+			bbconfig.QueryResponse{
+				// Length 1, resultCode success.
+				Expect: bbconfig.MustNewRegexp(`^\x01\x00`),
+			},
+		},
+		os...)
+}
+
+func (c *Config) AddRedisRule(server string, os ...*Option) {
+	os = append(defaults("redis", Timeout(5*time.Second)), os...)
+	c.AddTCPRule(server,
+		[]bbconfig.QueryResponse{
+			bbconfig.QueryResponse{
+				Send: "PING\r",
+			},
+			bbconfig.QueryResponse{
+				Expect: bbconfig.MustNewRegexp(`^\+PONG`),
+			},
+			bbconfig.QueryResponse{
+				Send: "QUIT\r",
+			},
+		},
+		os...)
+}
+
+func (c *Config) AddMemcachedRule(server string, os ...*Option) {
+	os = append(defaults("memcached", Timeout(5*time.Second)), os...)
+	c.AddTCPRule(server,
+		[]bbconfig.QueryResponse{
+			bbconfig.QueryResponse{
+				Send: "version\r",
+			},
+			bbconfig.QueryResponse{
+				Expect: bbconfig.MustNewRegexp(`^VERSION `),
+			},
+			bbconfig.QueryResponse{
+				Send: "quit\r",
+			},
+		},
+		os...)
+}
+
+// AddXMPPRule opens a client stream to domain.  If domain is empty, the host
+// part of server is used.  server must include a port.
+func (c *Config) AddXMPPRule(server, domain string, os ...*Option) {
+	host, _, err := net.SplitHostPort(server)
+	if err != nil {
+		host = server
+		os = append([]*Option{{
+			ModuleOption: func(m *Module) {
+				m.errs = append(m.errs, fmt.Errorf("AddXMPPRule(%q): %w", server, err))
+			}}}, os...)
+	}
+	if domain == "" {
+		domain = host
+	}
+	os = append(defaults(cleanName("xmpp_"+domain), Timeout(5*time.Second)), os...)
+	c.AddTCPRule(server,
+		[]bbconfig.QueryResponse{
+			bbconfig.QueryResponse{
+				Send: fmt.Sprintf("<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' "+
+					"xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>", domain),
+			},
+			bbconfig.QueryResponse{
+				Expect: bbconfig.MustNewRegexp(`<stream:stream\s`),
+			},
+			bbconfig.QueryResponse{
+				Send: "</stream:stream>",
+			},
+		},
+		os...)
+}
+
+func (c *Config) AddIRCRule(server string, os ...*Option) {
+	os = append(defaults("irc", Timeout(10*time.Second)), os...)
+	c.AddTCPRule(server,
+		[]bbconfig.QueryResponse{
+			bbconfig.QueryResponse{
+				Send: "NICK bbprober\r",
+			},
+			bbconfig.QueryResponse{
+				Send: "USER bbprober 0 * :blackbox_exporter\r",
+			},
+			bbconfig.QueryResponse{
+				// Either the welcome, or a PING the server wants answered
+				// before registering us.  Either way it's alive.
+				Expect: bbconfig.MustNewRegexp(`^(:\S+ 001 |PING )`),
+			},
+			bbconfig.QueryResponse{
+				Send: "QUIT\r",
+			},
+		},
+		os...)
+}
@@ -0,0 +1,266 @@
+package blackbox
+
+/*
+Copyright 2026 Robert Spier
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeLDAPRead reads a message sent as send, checking that it is well formed:
+// the prober's trailing newline must complete it exactly.
+func fakeLDAPRead(c *fakeConn, send string) bool {
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(c.r, hdr); err != nil || hdr[0] != 0x30 {
+		return false
+	}
+	body := make([]byte, hdr[1])
+	if _, err := io.ReadFull(c.r, body); err != nil {
+		return false
+	}
+	return string(hdr)+string(body) == send+"\n"
+}
+
+func TestProtocolDialogues(t *testing.T) {
+	tests := []struct {
+		name   string
+		add    func(c *Config, server string)
+		server func(c *fakeConn)
+		module string
+		want   bool
+	}{
+		{
+			name: "pop3",
+			add:  func(c *Config, s string) { c.AddPOP3Rule(s) },
+			server: func(c *fakeConn) {
+				c.send("+OK POP3 server ready")
+				if c.recv() == "QUIT" {
+					c.send("+OK bye")
+				}
+			},
+			module: "pop3",
+			want:   true,
+		},
+		{
+			name: "pop3 error",
+			add:  func(c *Config, s string) { c.AddPOP3Rule(s) },
+			server: func(c *fakeConn) {
+				c.send("-ERR go away")
+			},
+			want: false,
+		},
+		{
+			name: "ssh",
+			add:  func(c *Config, s string) { c.AddSSHRule(s, "") },
+			server: func(c *fakeConn) {
+				c.send("SSH-2.0-OpenSSH_9.6p1 Debian-4")
+				c.recv()
+			},
+			module: "ssh",
+			want:   true,
+		},
+		{
+			name: "ssh version",
+			add:  func(c *Config, s string) { c.AddSSHRule(s, `OpenSSH_9\.`) },
+			server: func(c *fakeConn) {
+				c.send("SSH-2.0-OpenSSH_9.6p1 Debian-4")
+				c.recv()
+			},
+			want: true,
+		},
+		{
+			name: "ssh old version",
+			add:  func(c *Config, s string) { c.AddSSHRule(s, `OpenSSH_9\.`) },
+			server: func(c *fakeConn) {
+				c.send("SSH-2.0-OpenSSH_7.4")
+			},
+			want: false,
+		},
+		{
+			name: "ftp",
+			add:  func(c *Config, s string) { c.AddFTPRule(s) },
+			server: func(c *fakeConn) {
+				c.send("220-Welcome", "220-to the fake", "220 FTP server")
+				if c.recv() == "QUIT" {
+					c.send("221 Goodbye.")
+				}
+			},
+			module: "ftp",
+			want:   true,
+		},
+		{
+			name: "ldap",
+			add:  func(c *Config, s string) { c.AddLDAPRule(s) },
+			server: func(c *fakeConn) {
+				if !fakeLDAPRead(c, ldapAnonymousBind) {
+					return
+				}
+				// BindResponse, message ID 1, resultCode success.
+				io.WriteString(c.Conn, "\x30\x0c\x02\x01\x01\x61\x07\x0a\x01\x00\x04\x00\x04\x00")
+				fakeLDAPRead(c, ldapUnbind)
+			},
+			module: "ldap",
+			want:   true,
+		},
+		{
+			name: "ldap bind refused",
+			add:  func(c *Config, s string) { c.AddLDAPRule(s) },
+			server: func(c *fakeConn) {
+				fakeLDAPRead(c, ldapAnonymousBind)
+				// resultCode inappropriateAuthentication (48).
+				io.WriteString(c.Conn, "\x30\x0c\x02\x01\x01\x61\x07\x0a\x01\x30\x04\x00\x04\x00")
+				fakeLDAPRead(c, ldapUnbind)
+			},
+			want: false,
+		},
+		{
+			name: "redis",
+			add:  func(c *Config, s string) { c.AddRedisRule(s) },
+			server: func(c *fakeConn) {
+				if c.recv() == "PING" {
+					c.send("+PONG")
+				}
+				c.recv()
+			},
+			module: "redis",
+			want:   true,
+		},
+		{
+			name: "redis noauth",
+			add:  func(c *Config, s string) { c.AddRedisRule(s) },
+			server: func(c *fakeConn) {
+				c.recv()
+				c.send("-NOAUTH Authentication required.")
+			},
+			want: false,
+		},
+		{
+			name: "memcached",
+			add:  func(c *Config, s string) { c.AddMemcachedRule(s) },
+			server: func(c *fakeConn) {
+				if c.recv() == "version" {
+					c.send("VERSION 1.6.21")
+				}
+				c.recv()
+			},
+			module: "memcached",
+			want:   true,
+		},
+		{
+			name: "xmpp",
+			add:  func(c *Config, s string) { c.AddXMPPRule(s, "example.com") },
+			server: func(c *fakeConn) {
+				if !strings.Contains(c.recv(), "to='example.com'") {
+					return
+				}
+				c.send("<?xml version='1.0'?><stream:stream id='1' from='example.com' version='1.0' " +
+					"xmlns:stream='http://etherx.jabber.org/streams' xmlns='jabber:client'>")
+				c.recv()
+			},
+			module: "xmpp_example_com",
+			want:   true,
+		},
+		{
+			name: "irc",
+			add:  func(c *Config, s string) { c.AddIRCRule(s) },
+			server: func(c *fakeConn) {
+				c.send(":irc.example.com NOTICE * :*** Looking up your hostname...")
+				c.recv()
+				c.recv()
+				c.send(":irc.example.com 001 bbprober :Welcome")
+				c.recv()
+			},
+			module: "irc",
+			want:   true,
+		},
+		{
+			name: "irc ping",
+			add:  func(c *Config, s string) { c.AddIRCRule(s) },
+			server: func(c *fakeConn) {
+				c.recv()
+				c.recv()
+				c.send("PING :12345678")
+				c.recv()
+			},
+			want: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			addr := fakeServer(t, tc.server)
+
+			c := newTestConfig()
+			tc.add(c, addr)
+			if tc.module != "" {
+				if _, ok := c.Modules[tc.module]; !ok {
+					t.Errorf("expected module %q, got %v", tc.module, c.Modules)
+				}
+			}
+			if err := c.Validate(); err != nil {
+				t.Fatalf("Validate failed: %v", err)
+			}
+			if got := runProbe(t, c, addr); got != tc.want {
+				t.Errorf("probe = %v; want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSSHRuleInvalidVersion(t *testing.T) {
+	c := newTestConfig()
+	c.AddSSHRule("ssh.example.com:22", "OpenSSH_(9")
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("expected Validate to report the invalid version regexp")
+	}
+	for _, want := range []string{`AddSSHRule("OpenSSH_(9")`, "ssh.example.com:22"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected error to mention %q, got: %v", want, err)
+		}
+	}
+}
+
+func TestSSHRuleVersionComment(t *testing.T) {
+	c := newTestConfig()
+	c.AddSSHRule("ssh.example.com:22", "OpenSSH_9")
+
+	bs, err := c.Marshal()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `AddSSHRule("OpenSSH_9")`; !strings.Contains(string(bs), want) {
+		t.Errorf("expected the module comment to mention %q, got:\n%s", want, bs)
+	}
+}
+
+func TestXMPPRuleWithoutPort(t *testing.T) {
+	c := newTestConfig()
+	c.AddXMPPRule("jabber.example.com", "")
+
+	if _, ok := c.Modules["xmpp_jabber_example_com"]; !ok {
+		t.Errorf("expected module xmpp_jabber_example_com, got %v", slices.Sorted(maps.Keys(c.Modules)))
+	}
+	err := c.Validate()
+	if err == nil || !strings.Contains(err.Error(), `AddXMPPRule("jabber.example.com"): address jabber.example.com: missing port in address`) {
+		t.Errorf("expected an error about the missing port, got: %v", err)
+	}
+}
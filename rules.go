@@ -0,0 +1,229 @@
+package blackbox
+
+/*
+Copyright 2026 Robert Spier
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+)
+
+// AlertFor sets how long a target's probe must fail before its alert fires.
+func AlertFor(d time.Duration) *Option {
+	return &Option{
+		TargetOption: func(t *Target) {
+			t.AlertFor = d
+		},
+	}
+}
+
+// Severity sets the severity label of a target's alerts.
+func Severity(s string) *Option {
+	return &Option{
+		TargetOption: func(t *Target) {
+			t.Severity = s
+		},
+	}
+}
+
+// Annotation adds an annotation to a target's alerts.
+func Annotation(k, v string) *Option {
+	return &Option{
+		TargetOption: func(t *Target) {
+			if t.Annotations == nil {
+				t.Annotations = make(map[string]string)
+			}
+			t.Annotations[k] = v
+		},
+	}
+}
+
+// CertExpiry alerts when the target's TLS certificate expires within d.
+func CertExpiry(d time.Duration) *Option {
+	return &Option{
+		TargetOption: func(t *Target) {
+			t.CertExpiry = d
+		},
+	}
+}
+
+type ruleFile struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []rule `yaml:"rules"`
+}
+
+type rule struct {
+	Alert       string            `yaml:"alert"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+var defaultAnnotations = map[string]string{
+	"summary": "Probe {{ $labels.name }} ({{ $labels.instance }}) failed",
+}
+
+var certExpiryAnnotations = map[string]string{
+	"summary": "Certificate for {{ $labels.instance }} expires in {{ $value | humanizeDuration }}",
+}
+
+// alertFor returns the for: duration and severity of t's alerts, falling
+// back to the Targets defaults.
+func (ts *Targets) alertFor(t Target) (time.Duration, string) {
+	d, s := t.AlertFor, t.Severity
+	if d == 0 {
+		d = ts.AlertFor
+	}
+	if s == "" {
+		s = ts.Severity
+	}
+	return d, s
+}
+
+func newRule(alert, expr string, d time.Duration, severity string, defaults, annotations map[string]string) rule {
+	r := rule{
+		Alert:       alert,
+		Expr:        expr,
+		Annotations: maps.Clone(defaults),
+	}
+	if d > 0 {
+		r.For = model.Duration(d).String()
+	}
+	if severity != "" {
+		r.Labels = map[string]string{"severity": severity}
+	}
+	maps.Copy(r.Annotations, annotations)
+	return r
+}
+
+// selector returns a PromQL series selector for the given label matchers.
+func selector(metric string, ms ...string) string {
+	return metric + "{" + strings.Join(ms, ",") + "}"
+}
+
+func matchEq(l, v string) string {
+	return l + "=" + strconv.Quote(v)
+}
+
+// rules returns a ProbeFailed alert for each target.
+func (ts *Targets) rules() []rule {
+	ts = ts.sorted()
+	var rs []rule
+	for _, t := range ts.Targets {
+		d, s := ts.alertFor(t)
+		expr := selector("probe_success", matchEq("module", t.Module), matchEq("instance", t.Destination)) + " == 0"
+		rs = append(rs, newRule("ProbeFailed", expr, d, s, defaultAnnotations, t.Annotations))
+	}
+	return append(rs, ts.certRules()...)
+}
+
+// certRules returns a CertExpiringSoon alert for each target with a
+// CertExpiry threshold.
+func (ts *Targets) certRules() []rule {
+	var rs []rule
+	for _, t := range ts.Targets {
+		if t.CertExpiry <= 0 {
+			continue
+		}
+		d, s := ts.alertFor(t)
+		expr := fmt.Sprintf("%s - time() < %d",
+			selector("probe_ssl_earliest_cert_expiry", matchEq("module", t.Module), matchEq("instance", t.Destination)),
+			int64(t.CertExpiry.Seconds()))
+		rs = append(rs, newRule("CertExpiringSoon", expr, d, s, certExpiryAnnotations, t.Annotations))
+	}
+	return rs
+}
+
+// moduleRules returns a ProbeFailed alert for each module.  Targets of the
+// same module with different alert options get separate alerts, matching
+// their instances.
+func (ts *Targets) moduleRules() []rule {
+	ts = ts.sorted()
+
+	type group struct {
+		module      string
+		d           time.Duration
+		severity    string
+		annotations map[string]string
+		dests       []string
+	}
+	groups := make(map[string]*group)
+	perModule := make(map[string]int)
+	var keys []string
+	for _, t := range ts.Targets {
+		d, s := ts.alertFor(t)
+		ab, _ := json.Marshal(t.Annotations)
+		k := fmt.Sprintf("%s|%d|%s|%s", t.Module, d, s, ab)
+		g, ok := groups[k]
+		if !ok {
+			g = &group{module: t.Module, d: d, severity: s, annotations: t.Annotations}
+			groups[k] = g
+			keys = append(keys, k)
+			perModule[t.Module]++
+		}
+		g.dests = append(g.dests, t.Destination)
+	}
+	sort.Strings(keys)
+
+	var rs []rule
+	for _, k := range keys {
+		g := groups[k]
+		ms := []string{matchEq("module", g.module)}
+		if perModule[g.module] > 1 {
+			var res []string
+			for _, d := range g.dests {
+				res = append(res, regexp.QuoteMeta(d))
+			}
+			ms = append(ms, "instance=~"+strconv.Quote(strings.Join(res, "|")))
+		}
+		expr := selector("probe_success", ms...) + " == 0"
+		rs = append(rs, newRule("ProbeFailed", expr, g.d, g.severity, defaultAnnotations, g.annotations))
+	}
+	return append(rs, ts.certRules()...)
+}
+
+func (ts *Targets) marshalRules(rs []rule) ([]byte, error) {
+	name := ts.JobName
+	if name == "" {
+		name = "blackbox"
+	}
+	return yaml.Marshal(ruleFile{Groups: []ruleGroup{{Name: name, Rules: rs}}})
+}
+
+// MarshalRules returns a Prometheus rule file with a ProbeFailed alert for
+// each target.
+func (ts *Targets) MarshalRules() ([]byte, error) {
+	return ts.marshalRules(ts.rules())
+}
+
+// MarshalModuleRules is like MarshalRules, but alerts per module.
+func (ts *Targets) MarshalModuleRules() ([]byte, error) {
+	return ts.marshalRules(ts.moduleRules())
+}
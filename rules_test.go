@@ -0,0 +1,142 @@
+package blackbox
+
+/*
+Copyright 2026 Robert Spier
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func rulesTestConfig() *Config {
+	c := &Config{
+		Modules: make(ModuleMap),
+		Targets: &Targets{
+			JobName:  "test_job",
+			AlertFor: 5 * time.Minute,
+			Severity: "page",
+		},
+	}
+	c.AddSimpleRule("https://example.com")
+	c.AddSimpleRule("https://www.example.com")
+	c.AddSimpleRule("https://slow.example.com",
+		AlertFor(30*time.Minute),
+		Severity("ticket"),
+		Annotation("runbook", "https://wiki.example.com/slow"))
+	c.AddDNSRule("8.8.8.8", "A", "example.com")
+	return c
+}
+
+func TestMarshalRulesGolden(t *testing.T) {
+	got, err := rulesTestConfig().Targets.MarshalRules()
+	if err != nil {
+		t.Fatalf("MarshalRules failed: %v", err)
+	}
+	checkGolden(t, "rules_marshal.golden", got)
+}
+
+func TestMarshalModuleRulesGolden(t *testing.T) {
+	got, err := rulesTestConfig().Targets.MarshalModuleRules()
+	if err != nil {
+		t.Fatalf("MarshalModuleRules failed: %v", err)
+	}
+	checkGolden(t, "module_rules_marshal.golden", got)
+}
+
+func TestAlertOptionsKeepTargetName(t *testing.T) {
+	c := rulesTestConfig()
+	for _, tgt := range c.Targets.Targets {
+		if tgt.Destination == "https://slow.example.com" && tgt.Name != tgt.Destination {
+			t.Errorf("expected target-only options to leave the name alone, got %q", tgt.Name)
+		}
+	}
+}
+
+func TestRunRulesFile(t *testing.T) {
+	dir := t.TempDir()
+	setFlag(t, "blackboxfile", filepath.Join(dir, "blackbox.yaml"))
+	setFlag(t, "targetsfile", filepath.Join(dir, "prometheus.yaml"))
+	setFlag(t, "rulesfile", filepath.Join(dir, "rules.yaml"))
+
+	err := Run(context.Background(), func(c *Config) {
+		c.AddSimpleRule("https://example.com")
+	})
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "rules.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"alert: ProbeFailed", "for: 5m", "severity: page"} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("expected rules file to contain %q, got:\n%s", want, got)
+		}
+	}
+}
+
+func TestAddCertExpiryRule(t *testing.T) {
+	c := rulesTestConfig()
+	c.AddCertExpiryRule("https://example.com", 14*24*time.Hour)
+	c.AddCertExpiryRule("mail.example.com:465", 7*24*time.Hour)
+
+	// The existing https://example.com target is reused.
+	if len(c.Targets.Targets) != 5 {
+		t.Fatalf("expected 5 targets, got %d: %+v", len(c.Targets.Targets), c.Targets.Targets)
+	}
+
+	var tcp *Target
+	for i, tgt := range c.Targets.Targets {
+		if tgt.Destination == "mail.example.com:465" {
+			tcp = &c.Targets.Targets[i]
+		}
+	}
+	if tcp == nil {
+		t.Fatal("expected a target for mail.example.com:465")
+	}
+	if m := c.Modules[tcp.Module]; m == nil || !m.Module.TCP.TLS {
+		t.Errorf("expected a TCP+TLS module for mail.example.com:465, got %+v", m)
+	}
+
+	got, err := c.Targets.MarshalRules()
+	if err != nil {
+		t.Fatalf("MarshalRules failed: %v", err)
+	}
+	checkGolden(t, "cert_rules_marshal.golden", got)
+}
+
+func TestAddCertExpiryRuleInvalid(t *testing.T) {
+	for _, tc := range []struct {
+		url        string
+		warnBefore time.Duration
+		want       string
+	}{
+		{"http://example.org", 14 * 24 * time.Hour, `AddCertExpiryRule("http://example.org"): an http:// URL has no certificate`},
+		{"https://example.org", 0, `AddCertExpiryRule("https://example.org"): warnBefore 0s isn't positive`},
+		{"mail.example.org:465", -time.Hour, `AddCertExpiryRule("mail.example.org:465"): warnBefore -1h0m0s isn't positive`},
+	} {
+		c := newTestConfig()
+		c.AddCertExpiryRule(tc.url, tc.warnBefore)
+		if err := c.Validate(); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("AddCertExpiryRule(%q, %v): got error %v, want %q", tc.url, tc.warnBefore, err, tc.want)
+		}
+	}
+}
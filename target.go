@@ -19,11 +19,18 @@ limitations under the License.
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"regexp"
+	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"text/template"
-
-	"github.com/golang/glog"
+	"time"
 )
 
 type Target struct {
@@ -31,6 +38,21 @@ type Target struct {
 	Destination    string
 	Name           string
 	ScrapeInterval int
+	// Extra labels for the probe's series.
+	Labels map[string]string
+
+	// Alerting options, see MarshalRules.
+	AlertFor    time.Duration
+	Severity    string
+	Annotations map[string]string
+	// If non-zero, alert when the certificate expires within this long.
+	CertExpiry time.Duration
+	// ModuleTimeout is the Timeout of the target's module.  The target is
+	// scraped with a scrape timeout a little longer.  Targets.Add sets it.
+	ModuleTimeout time.Duration
+
+	// source is where the rule that added the target was called.
+	source string
 }
 
 type Targets struct {
@@ -38,15 +60,20 @@ type Targets struct {
 	JobName          string
 	BlackboxHostPort string
 	ScrapeInterval   int
+
+	// Defaults for targets without their own alerting options.
+	AlertFor time.Duration
+	Severity string
 }
 
 type TargetOption func(t *Target)
 
 func (ts *Targets) Add(m *Module, d, n string, os ...*Option) Target {
 	t := Target{
-		Module:      m.Name,
-		Destination: d,
-		Name:        n,
+		Module:        m.Name,
+		Destination:   d,
+		Name:          n,
+		ModuleTimeout: m.Module.Timeout,
 	}
 	t.applyOptions(os...)
 	ts.Targets = append(ts.Targets, t)
@@ -62,6 +89,58 @@ func (t *Target) applyOptions(os ...*Option) {
 	}
 }
 
+// Label adds a label to the target's series.
+func Label(k, v string) *Option {
+	return &Option{
+		TargetOption: func(t *Target) {
+			if t.Labels == nil {
+				t.Labels = make(map[string]string)
+			}
+			t.Labels[k] = v
+		},
+	}
+}
+
+// labels returns the labels identifying t's series, other than instance.
+func (t Target) labels() map[string]string {
+	ls := map[string]string{
+		"module": t.Module,
+		"name":   t.Name,
+	}
+	maps.Copy(ls, t.Labels)
+	return ls
+}
+
+var labelName = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// reservedLabels are set by the generated scrape configs.
+var reservedLabels = map[string]bool{
+	"module":   true,
+	"name":     true,
+	"instance": true,
+	"job":      true,
+}
+
+// validate checks that the targets can be represented in a scrape config.
+func (ts *Targets) validate() error {
+	var errs []error
+	for _, t := range ts.Targets {
+		if t.Destination == "" {
+			errs = append(errs, fmt.Errorf("target %q (module %s): empty destination", t.Name, t.Module))
+		}
+		if k := ts.scrapeKey(t); k.interval > 0 && k.timeout > k.interval {
+			errs = append(errs, fmt.Errorf("target %q (%s): module timeout %v needs a scrape timeout of %ds, longer than the scrape interval %ds",
+				t.Name, t.Destination, t.ModuleTimeout, k.timeout, k.interval))
+		}
+		for k := range t.Labels {
+			if !labelName.MatchString(k) || strings.HasPrefix(k, "__") || reservedLabels[k] {
+				errs = append(errs, fmt.Errorf("target %q (%s): invalid label name %q", t.Name, t.Destination, k))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
 func ScrapeInterval(si int) *Option {
 	return &Option{
 		TargetOption: func(t *Target) {
@@ -77,75 +156,152 @@ var header = `global:
 scrape_configs:
 `
 
-var scCfgTmpl = `{{ range . }}- job_name: '{{ .JobName }}_{{ .ScrapeInterval }}'
-  scrape_interval: {{ .ScrapeInterval }}s
-  metrics_path: /probe
-  static_configs:
-  - targets:{{ range .Targets }}
-    - {{.Module}}|{{.Destination}}|{{.Name}}{{end}}
-  relabel_configs:
+// probeRelabelConfigs turns a target labelled with its module into a
+// request to the blackbox exporter.
+const probeRelabelConfigs = `  relabel_configs:
   - source_labels: [__address__]
-    regex: (.+)\|(.+)\|(.+)
     target_label: __param_target
-    replacement: ${2}
-  - source_labels: [__address__]
-    regex: (.+)\|(.+)\|(.+)
+  - source_labels: [module]
     target_label: __param_module
-    replacement: ${1}
-  - source_labels: [__address__]
-    regex: (.+)\|(.+)\|(.+)
-    target_label: module
-    replacement: ${1}
-  - source_labels: [__address__]
-    regex: (.+)\|(.+)\|(.+)
-    target_label: name
-    replacement: ${3}
   - source_labels: [__param_target]
     target_label: instance
   - target_label: __address__
-    replacement: {{.BlackboxHostPort}}
-{{ end }}
+    replacement: {{ .BlackboxHostPort }}
 `
 
-func (ts *Targets) Marshal() []byte {
+var scCfgTmpl = `{{ range . }}- job_name: '{{ .JobName }}_{{ .ScrapeInterval }}'
+  scrape_interval: {{ .ScrapeInterval }}s
+  metrics_path: /probe
+  static_configs:{{ range .Groups }}
+  - targets:{{ range .Targets }}{{ with .Source }}
+    # Added by {{ . }}.{{ end }}
+    - {{ str .Destination }}{{ end }}
+    labels:{{ range $k, $v := .Labels }}
+      {{ $k }}: {{ str $v }}{{ end }}{{ end }}
+{{ template "relabel" . }}{{ end }}`
+
+func (ts *Targets) Marshal() ([]byte, error) {
+	sc, err := ts.marshal()
+	if err != nil {
+		return nil, err
+	}
 	var b bytes.Buffer
 	b.WriteString(header)
-	b.Write(ts.marshal())
-	return b.Bytes()
+	b.Write(sc)
+	return b.Bytes(), nil
 }
 
-func (ts *Targets) MarshalSC() []byte {
+func (ts *Targets) MarshalSC() ([]byte, error) {
 	return ts.marshal()
 }
 
+// scrapeTimeoutMargin is added to module timeouts to get scrape timeouts.
+// The exporter gives up half a second before the scrape timeout.
+const scrapeTimeoutMargin = time.Second
+
+// scrapeKey is how often a target is scraped, and the scrape timeout it needs,
+// in seconds.  A zero timeout leaves Prometheus' default.
+type scrapeKey struct {
+	interval int
+	timeout  int
+}
+
+func (ts *Targets) scrapeKey(t Target) scrapeKey {
+	k := scrapeKey{interval: t.ScrapeInterval}
+	if k.interval == 0 {
+		k.interval = ts.ScrapeInterval
+	}
+	if t.ModuleTimeout > 0 {
+		k.timeout = int(math.Ceil((t.ModuleTimeout + scrapeTimeoutMargin).Seconds()))
+	}
+	return k
+}
+
+// scrapeLabels returns t's labels, with the scrape timeout its module needs
+// in __scrape_timeout__.
+func (ts *Targets) scrapeLabels(t Target) map[string]string {
+	ls := t.labels()
+	if k := ts.scrapeKey(t); k.timeout > 0 {
+		ls["__scrape_timeout__"] = strconv.Itoa(k.timeout) + "s"
+	}
+	return ls
+}
+
 func (ts *Targets) byScrapeInterval() map[int][]Target {
 	out := make(map[int][]Target)
 	for _, t := range ts.Targets {
-		si := t.ScrapeInterval
-		if si == 0 {
-			si = ts.ScrapeInterval
-		}
+		si := ts.scrapeKey(t).interval
 		out[si] = append(out[si], t)
 	}
 	return out
 }
 
-var tmpl = template.Must(template.New("targets").Parse(scCfgTmpl))
+// byScrape groups the targets with the same scrape settings.
+func (ts *Targets) byScrape() map[scrapeKey][]Target {
+	out := make(map[scrapeKey][]Target)
+	for _, t := range ts.Targets {
+		k := ts.scrapeKey(t)
+		out[k] = append(out[k], t)
+	}
+	return out
+}
+
+var tmplFuncs = template.FuncMap{
+	// str quotes a string for YAML.  JSON strings are valid YAML.
+	"str": func(s string) (string, error) {
+		b, err := json.Marshal(s)
+		return string(b), err
+	},
+}
+
+func newTemplate(name, text string) *template.Template {
+	t := template.New(name).Funcs(tmplFuncs)
+	template.Must(t.New("relabel").Parse(probeRelabelConfigs))
+	return template.Must(t.Parse(text))
+}
+
+var tmpl = newTemplate("targets", scCfgTmpl)
 
 func trimScheme(s string) string {
 	return strings.TrimPrefix(strings.TrimPrefix(s, "https://"), "http://")
 }
 
-func (ts *Targets) sort() {
-	sort.SliceStable(ts.Targets, func(i, j int) bool {
-		ii := trimScheme(ts.Targets[i].Destination) + ts.Targets[i].Name + ts.Targets[i].Module
-		jj := trimScheme(ts.Targets[j].Destination) + ts.Targets[j].Name + ts.Targets[j].Module
+// sorted returns a copy of ts with the targets in output order, leaving the
+// order of ts.Targets alone.
+func (ts *Targets) sorted() *Targets {
+	out := *ts
+	out.Targets = slices.Clone(ts.Targets)
+	sort.SliceStable(out.Targets, func(i, j int) bool {
+		ii := trimScheme(out.Targets[i].Destination) + out.Targets[i].Name + out.Targets[i].Module
+		jj := trimScheme(out.Targets[j].Destination) + out.Targets[j].Name + out.Targets[j].Module
 		return ii < jj
 	})
+	return &out
+}
+
+// staticGroup is a TargetGroup with the source of each target, for comments
+// in a static config.
+type staticGroup struct {
+	Targets []struct{ Destination, Source string }
+	Labels  map[string]string
 }
 
-func (ts *Targets) marshal() []byte {
-	ts.sort()
+func staticGroups(tgs []TargetGroup) []staticGroup {
+	out := make([]staticGroup, len(tgs))
+	for i, tg := range tgs {
+		out[i].Labels = tg.Labels
+		for j, d := range tg.Targets {
+			out[i].Targets = append(out[i].Targets, struct{ Destination, Source string }{d, tg.sources[j]})
+		}
+	}
+	return out
+}
+
+func (ts *Targets) marshal() ([]byte, error) {
+	if err := ts.validate(); err != nil {
+		return nil, err
+	}
+	ts = ts.sorted()
 	tsis := ts.byScrapeInterval()
 
 	var b bytes.Buffer
@@ -153,7 +309,7 @@ func (ts *Targets) marshal() []byte {
 	type tmplD struct {
 		JobName          string
 		ScrapeInterval   int
-		Targets          []Target
+		Groups           []staticGroup
 		BlackboxHostPort string
 	}
 	var cfgs []*tmplD
@@ -163,7 +319,7 @@ func (ts *Targets) marshal() []byte {
 			JobName:          ts.JobName,
 			BlackboxHostPort: ts.BlackboxHostPort,
 			ScrapeInterval:   si,
-			Targets:          tsi,
+			Groups:           staticGroups(groupTargets(tsi, ts.scrapeLabels)),
 		}
 		cfgs = append(cfgs, d)
 	}
@@ -179,10 +335,8 @@ func (ts *Targets) marshal() []byte {
 		return cfgs[i].JobName < cfgs[j].JobName
 	})
 
-	err := tmpl.Execute(&b, cfgs)
-	if err != nil {
-		glog.Fatal(err)
+	if err := tmpl.Execute(&b, cfgs); err != nil {
+		return nil, err
 	}
-	return b.Bytes()
-
+	return b.Bytes(), nil
 }
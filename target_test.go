@@ -17,9 +17,15 @@ limitations under the License.
 */
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 func TestTrimScheme(t *testing.T) {
@@ -81,7 +87,10 @@ func TestTargetsMarshalGolden(t *testing.T) {
 	ts.Add(m2, "http://example.com", "redir_to_https_example_com")
 	ts.Add(m1, "https://slow.example.com", "slow.example.com", ScrapeInterval(60))
 
-	got := ts.Marshal()
+	got, err := ts.Marshal()
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
 
 	goldenPath := filepath.Join("testdata", "targets_marshal.golden")
 
@@ -103,10 +112,246 @@ func TestTargetsMarshalGolden(t *testing.T) {
 		t.Errorf("Targets.Marshal output mismatch\ngot:\n%s\nwant:\n%s", string(got), string(want))
 	}
 
-	gotSC := ts.MarshalSC()
+	gotSC, err := ts.MarshalSC()
+	if err != nil {
+		t.Fatalf("MarshalSC failed: %v", err)
+	}
 	if len(gotSC) == 0 {
 		t.Error("expected non-empty output from MarshalSC")
 	}
 }
 
+func TestLabel(t *testing.T) {
+	ts := &Targets{
+		JobName:          "test_job",
+		BlackboxHostPort: "localhost:9115",
+		ScrapeInterval:   30,
+	}
+	m := &Module{Name: "http_200", Module: BaseHTTPModule(200)}
+	ts.Add(m, "https://example.com", "example.com", Label("team", "web"), Label("env", "prod"))
+	ts.Add(m, "https://www.example.com", "example.com", Label("team", "web"), Label("env", "prod"))
+	ts.Add(m, "https://staging.example.com", "staging.example.com", Label("team", "web"), Label("env", "staging"))
+
+	got, err := ts.MarshalSC()
+	if err != nil {
+		t.Fatalf("MarshalSC failed: %v", err)
+	}
+	checkGolden(t, "targets_labels_marshal.golden", got)
+
+	var sc []struct {
+		StaticConfigs []TargetGroup `yaml:"static_configs"`
+	}
+	if err := yaml.Unmarshal(got, &sc); err != nil {
+		t.Fatalf("output is not valid YAML: %v", err)
+	}
+	if len(sc) != 1 || len(sc[0].StaticConfigs) != 2 {
+		t.Fatalf("expected 1 job with 2 static configs, got %+v", sc)
+	}
+	if ls := sc[0].StaticConfigs[0].Labels; ls["team"] != "web" || ls["env"] != "prod" {
+		t.Errorf("expected team and env labels, got %v", ls)
+	}
+}
+
+func TestLabelInvalid(t *testing.T) {
+	for _, k := range []string{"module", "instance", "__param_target", "bad-name", ""} {
+		t.Run(k, func(t *testing.T) {
+			ts := &Targets{}
+			m := &Module{Name: "http_200", Module: BaseHTTPModule(200)}
+			ts.Add(m, "https://example.com", "example.com", Label(k, "x"))
+
+			_, err := ts.Marshal()
+			if err == nil {
+				t.Fatal("expected invalid label name to be rejected")
+			}
+			if !strings.Contains(err.Error(), "example.com") {
+				t.Errorf("expected error to name the target, got: %v", err)
+			}
+		})
+	}
+}
+
+// Destinations and names used to be packed into __address__ separated by
+// '|', so make sure awkward ones survive intact.
+var awkwardTargets = []struct {
+	dest string
+	name string
+}{
+	{"https://example.com/search?q=a|b", "search"},
+	{"https://example.com/|/|", "pipes|everywhere|"},
+	{"txt|record.example.com", "dns|txt"},
+	{"https://example.com/\"quoted\"", "it's: a name #1"},
+	{"https://example.com/${2}", "$1"},
+}
+
+func TestMarshalAwkwardTargets(t *testing.T) {
+	ts := &Targets{JobName: "test_job", ScrapeInterval: 30}
+	m := &Module{Name: "http_200", Module: BaseHTTPModule(200)}
+	for _, at := range awkwardTargets {
+		ts.Add(m, at.dest, at.name)
+	}
+
+	got, err := ts.MarshalSC()
+	if err != nil {
+		t.Fatalf("MarshalSC failed: %v", err)
+	}
+
+	var sc []struct {
+		StaticConfigs []TargetGroup `yaml:"static_configs"`
+	}
+	if err := yaml.Unmarshal(got, &sc); err != nil {
+		t.Fatalf("output is not valid YAML: %v\n%s", err, got)
+	}
+
+	names := make(map[string]string)
+	for _, g := range sc[0].StaticConfigs {
+		for _, d := range g.Targets {
+			names[d] = g.Labels["name"]
+		}
+	}
+	for _, at := range awkwardTargets {
+		if got, ok := names[at.dest]; !ok || got != at.name {
+			t.Errorf("target %q: got name %q (present: %v); want %q", at.dest, got, ok, at.name)
+		}
+	}
+}
+
+func TestMarshalProbesAwkwardTargets(t *testing.T) {
+	ts := &Targets{JobName: "test_job", ScrapeInterval: 30}
+	m := &Module{Name: "http_200", Module: BaseHTTPModule(200)}
+	for _, at := range awkwardTargets {
+		ts.Add(m, at.dest, at.name)
+	}
+
+	got, err := ts.MarshalProbes("")
+	if err != nil {
+		t.Fatalf("MarshalProbes failed: %v", err)
+	}
+	var p probe
+	if err := yaml.Unmarshal(got, &p); err != nil {
+		t.Fatalf("output is not valid YAML: %v", err)
+	}
 
+	// Prometheus anchors relabeling regexes, so each must match only its
+	// own destination.
+	for _, rc := range p.Spec.Targets.StaticConfig.RelabelingConfigs {
+		re := regexp.MustCompile("^(?:" + rc.Regex + ")$")
+		var matched []string
+		for _, at := range awkwardTargets {
+			if re.MatchString(at.dest) {
+				matched = append(matched, at.dest)
+			}
+		}
+		if len(matched) != 1 {
+			t.Errorf("relabeling regex %q matched %q; want exactly one destination", rc.Regex, matched)
+		}
+	}
+}
+
+func TestEmptyDestination(t *testing.T) {
+	ts := &Targets{}
+	m := &Module{Name: "http_200", Module: BaseHTTPModule(200)}
+	ts.Add(m, "", "nowhere")
+
+	_, err := ts.MarshalSC()
+	if err == nil || !strings.Contains(err.Error(), "nowhere") {
+		t.Errorf("expected an error naming the target, got: %v", err)
+	}
+}
+
+// scrapeTimeoutTestTargets returns targets of modules with and without
+// timeouts.
+func scrapeTimeoutTestTargets() *Targets {
+	ts := &Targets{
+		JobName:          "test_job",
+		BlackboxHostPort: "localhost:9115",
+		ScrapeInterval:   30,
+	}
+	web := &Module{Name: "http_200", Module: BaseHTTPModule(200)}
+	slow := &Module{Name: "slow", Module: BaseHTTPModule(200)}
+	slow.Module.Timeout = 15 * time.Second
+	smtp := &Module{Name: "smtp", Module: BaseTCPModule()}
+	smtp.Module.Timeout = 4500 * time.Millisecond
+
+	ts.Add(web, "https://example.com", "example.com")
+	ts.Add(slow, "https://slow.example.com", "slow.example.com")
+	ts.Add(smtp, "mail.example.com:25", "smtp")
+	ts.Add(smtp, "mail2.example.com:25", "smtp", ScrapeInterval(60))
+	return ts
+}
+
+func TestScrapeTimeout(t *testing.T) {
+	ts := scrapeTimeoutTestTargets()
+	got, err := ts.MarshalSC()
+	if err != nil {
+		t.Fatalf("MarshalSC failed: %v", err)
+	}
+	checkGolden(t, "scrape_timeout_marshal.golden", got)
+
+	var jobs []struct {
+		JobName       string `yaml:"job_name"`
+		StaticConfigs []struct {
+			Targets []string          `yaml:"targets"`
+			Labels  map[string]string `yaml:"labels"`
+		} `yaml:"static_configs"`
+	}
+	if err := yaml.Unmarshal(got, &jobs); err != nil {
+		t.Fatalf("generated scrape config doesn't parse: %v", err)
+	}
+	want := []string{
+		"test_job_30 [https://slow.example.com] 16s",
+		"test_job_30 [mail.example.com:25] 6s",
+		"test_job_30 [https://example.com] ",
+		"test_job_60 [mail2.example.com:25] 6s",
+	}
+	var gotTargets []string
+	for _, j := range jobs {
+		for _, sc := range j.StaticConfigs {
+			gotTargets = append(gotTargets, fmt.Sprintf("%s %v %s", j.JobName, sc.Targets, sc.Labels["__scrape_timeout__"]))
+		}
+	}
+	if strings.Join(gotTargets, "\n") != strings.Join(want, "\n") {
+		t.Errorf("targets =\n%s\nwant\n%s", strings.Join(gotTargets, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestScrapeTimeoutAppendedTarget(t *testing.T) {
+	ts := scrapeTimeoutTestTargets()
+	ts.Targets = append(ts.Targets, Target{Module: "raw", Destination: "raw.example.com:80", Name: "raw", ModuleTimeout: 9 * time.Second})
+
+	got, err := ts.MarshalSC()
+	if err != nil {
+		t.Fatalf("MarshalSC failed: %v", err)
+	}
+	if !strings.Contains(string(got), "- \"raw.example.com:80\"\n    labels:\n      __scrape_timeout__: \"10s\"\n") {
+		t.Errorf("expected a 10s __scrape_timeout__ for the appended target, got:\n%s", got)
+	}
+}
+
+func TestScrapeTimeoutOtherFormats(t *testing.T) {
+	ts := scrapeTimeoutTestTargets()
+
+	probes, err := ts.MarshalProbes("")
+	if err != nil {
+		t.Fatalf("MarshalProbes failed: %v", err)
+	}
+	if !strings.Contains(string(probes), "interval: 30s\n  scrapeTimeout: 16s\n  module: slow\n") {
+		t.Errorf("expected a 16s scrapeTimeout for the slow Probe, got:\n%s", probes)
+	}
+
+	for _, g := range ts.FileSDGroups() {
+		want := map[string]string{"http_200": "", "slow": "16s", "smtp": "6s"}[g.Labels["module"]]
+		if got := g.Labels["__scrape_timeout__"]; got != want {
+			t.Errorf("group %v: __scrape_timeout__ = %q; want %q", g.Targets, got, want)
+		}
+	}
+}
+
+func TestScrapeTimeoutLongerThanInterval(t *testing.T) {
+	ts := scrapeTimeoutTestTargets()
+	ts.Targets[1].ScrapeInterval = 10
+
+	_, err := ts.MarshalSC()
+	if err == nil || !strings.Contains(err.Error(), `target "slow.example.com" (https://slow.example.com): module timeout 15s needs a scrape timeout of 16s, longer than the scrape interval 10s`) {
+		t.Errorf("expected an error about the slow target's timeout, got: %v", err)
+	}
+}